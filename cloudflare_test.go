@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	cloudflare "github.com/cloudflare/cloudflare-go"
 	"github.com/coredns/caddy"
@@ -455,6 +456,37 @@ func TestCloudflareAutoEnablesTraefik(t *testing.T) {
 	assert.Equal(t, "traefik.homelab.net", dd.traefikCNAME)
 }
 
+func TestCloudflareRemovesDroppedDomains(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	traefik_cname traefik.homelab.net
+	traefik_default_rule Host(`+"`{{ .Name }}.homelab.net`"+`)
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	mock := newMockCloudflareAPI()
+	dd.cloudflareSyncer = NewCloudflareSyncerWithAPI(&CloudflareConfig{
+		TargetDomain:   "traefik.homelab.net",
+		ExcludeDomains: make(map[string]bool),
+		Zones:          []CloudflareZone{{Domain: "homelab.net", ZoneID: "zone_1"}},
+	}, mock)
+	names := func() []string {
+		var names []string
+		for _, rec := range mock.allRecords() {
+			names = append(names, rec.Name)
+		}
+		return names
+	}
+
+	container := genRunningContainer("aa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "app", "172.17.0.2")
+	assert.Nil(t, dd.updateContainerInfo(container))
+	assert.Eventually(t, func() bool { return assert.ObjectsAreEqual([]string{"app.homelab.net"}, names()) }, 2*time.Second, 10*time.Millisecond)
+
+	// Renaming the container replaces its host
+	container.Name = "/web"
+	assert.Nil(t, dd.updateContainerInfo(container))
+	assert.Eventually(t, func() bool { return assert.ObjectsAreEqual([]string{"web.homelab.net"}, names()) }, 2*time.Second, 10*time.Millisecond)
+}
+
 // --- Tunnel syncer tests ---
 
 func newTunnelTestSetup() (*mockCloudflareAPI, *TunnelSyncer) {
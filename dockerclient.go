@@ -0,0 +1,251 @@
+package dockerdiscovery
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+// dockerTLSFiles are the certificate files of a TLS endpoint (tls_ca,
+// tls_cert, tls_key). Without a CA the daemon's certificate is verified
+// against the system roots, and not at all with skipVerify only, which is
+// set by docker contexts.
+type dockerTLSFiles struct {
+	ca         string
+	cert       string
+	key        string
+	skipVerify bool
+}
+
+// newDockerClient returns a client of the endpoint: a unix socket, a
+// tcp:// daemon (over TLS when files are given) or ssh://[user@]host[:port],
+// reached through the docker CLI of the remote host.
+func newDockerClient(endpoint string, tls *dockerTLSFiles) (*dockerapi.Client, error) {
+	if strings.HasPrefix(endpoint, "ssh://") {
+		return newSSHDockerClient(endpoint)
+	}
+	if tls != nil && !strings.HasPrefix(endpoint, "unix://") {
+		client, err := dockerapi.NewTLSClient(endpoint, tls.cert, tls.key, tls.ca)
+		if err != nil {
+			return nil, err
+		}
+		// go-dockerclient skips verification when there is no CA
+		client.TLSConfig.InsecureSkipVerify = tls.skipVerify
+		return client, nil
+	}
+	return dockerapi.NewClient(endpoint)
+}
+
+// certDirTLSFiles returns the TLS files of a certificate directory, laid
+// out like DOCKER_CERT_PATH: ca.pem, cert.pem and key.pem.
+func certDirTLSFiles(dir string) *dockerTLSFiles {
+	return &dockerTLSFiles{
+		ca:   filepath.Join(dir, "ca.pem"),
+		cert: filepath.Join(dir, "cert.pem"),
+		key:  filepath.Join(dir, "key.pem"),
+	}
+}
+
+// endpointFromEnv returns the endpoint the docker CLI uses by default, and
+// its TLS files: DOCKER_HOST (with DOCKER_TLS_VERIFY and DOCKER_CERT_PATH),
+// else the endpoint of the current context, named by DOCKER_CONTEXT or the
+// currentContext of the CLI configuration. It returns an empty endpoint when
+// none is set.
+func endpointFromEnv() (string, *dockerTLSFiles, error) {
+	configDir := os.Getenv("DOCKER_CONFIG")
+	if configDir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configDir = filepath.Join(home, ".docker")
+		}
+	}
+
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		if os.Getenv("DOCKER_TLS_VERIFY") == "" {
+			return host, nil, nil
+		}
+		certPath := os.Getenv("DOCKER_CERT_PATH")
+		if certPath == "" {
+			certPath = configDir
+		}
+		return host, certDirTLSFiles(certPath), nil
+	}
+
+	name := os.Getenv("DOCKER_CONTEXT")
+	if name == "" && configDir != "" {
+		var config struct {
+			CurrentContext string `json:"currentContext"`
+		}
+		if data, err := os.ReadFile(filepath.Join(configDir, "config.json")); err == nil {
+			if err := json.Unmarshal(data, &config); err != nil {
+				return "", nil, fmt.Errorf("docker CLI configuration: %s", err)
+			}
+		}
+		name = config.CurrentContext
+	}
+	if name == "" || name == "default" {
+		return "", nil, nil
+	}
+	return contextEndpoint(configDir, name)
+}
+
+// contextEndpoint reads the Docker endpoint of a docker CLI context.
+func contextEndpoint(configDir string, name string) (string, *dockerTLSFiles, error) {
+	digest := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(digest[:])
+
+	var meta struct {
+		Endpoints map[string]struct {
+			Host          string
+			SkipTLSVerify bool
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(configDir, "contexts", "meta", id, "meta.json"))
+	if err != nil {
+		return "", nil, fmt.Errorf("docker context %s: %s", name, err)
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return "", nil, fmt.Errorf("docker context %s: %s", name, err)
+	}
+	endpoint, ok := meta.Endpoints["docker"]
+	if !ok || endpoint.Host == "" {
+		return "", nil, fmt.Errorf("docker context %s has no docker endpoint", name)
+	}
+
+	tlsDir := filepath.Join(configDir, "contexts", "tls", id, "docker")
+	if _, err := os.Stat(tlsDir); err != nil {
+		return endpoint.Host, nil, nil
+	}
+	tls := &dockerTLSFiles{
+		cert:       filepath.Join(tlsDir, "cert.pem"),
+		key:        filepath.Join(tlsDir, "key.pem"),
+		skipVerify: endpoint.SkipTLSVerify,
+	}
+	if !endpoint.SkipTLSVerify {
+		tls.ca = filepath.Join(tlsDir, "ca.pem")
+	}
+	return endpoint.Host, tls, nil
+}
+
+// sshDialer connects to the Docker API of a remote host by running
+// "docker system dial-stdio" there over ssh, like the docker CLI does.
+type sshDialer struct {
+	args []string
+}
+
+func newSSHDialer(endpoint string) (*sshDialer, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "ssh" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid ssh endpoint '%s'", endpoint)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, fmt.Errorf("ssh endpoint '%s' can't have a path", endpoint)
+	}
+	var args []string
+	if u.User != nil {
+		args = append(args, "-l", u.User.Username())
+	}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	args = append(args, "--", u.Hostname(), "docker", "system", "dial-stdio")
+	return &sshDialer{args: args}, nil
+}
+
+// Dial implements dockerapi.Dialer; the address is the one of the fake
+// http:// endpoint of the client, and is ignored.
+func (d *sshDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext only uses the context to give up before dialing: connections
+// outlive the request they are dialed for, in the client's idle pool.
+func (d *sshDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return dialCommand("ssh", d.args...)
+}
+
+// newSSHDockerClient returns a client whose connections are made by an
+// sshDialer. Requests go to a placeholder http:// endpoint.
+func newSSHDockerClient(endpoint string) (*dockerapi.Client, error) {
+	dialer, err := newSSHDialer(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dockerapi.NewClient("http://docker.ssh:2375")
+	if err != nil {
+		return nil, err
+	}
+	client.Dialer = dialer
+	client.HTTPClient = &http.Client{Transport: &http.Transport{
+		DialContext:     dialer.DialContext,
+		MaxIdleConns:    2,
+		IdleConnTimeout: 30 * time.Second,
+	}}
+	return client, nil
+}
+
+// commandConn is a net.Conn over the standard input and output of a command.
+type commandConn struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stdout    io.ReadCloser
+	closeOnce sync.Once
+}
+
+// dialCommand starts the command and returns a connection to it. The
+// command runs until the connection is closed.
+func dialCommand(name string, args ...string) (net.Conn, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+}
+
+func (c *commandConn) Read(p []byte) (int, error)  { return c.stdout.Read(p) }
+func (c *commandConn) Write(p []byte) (int, error) { return c.stdin.Write(p) }
+
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.stdin.Close()
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	})
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr                { return commandAddr{} }
+func (c *commandConn) RemoteAddr() net.Addr               { return commandAddr{} }
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type commandAddr struct{}
+
+func (commandAddr) Network() string { return "command" }
+func (commandAddr) String() string  { return "command" }
@@ -0,0 +1,274 @@
+package dockerdiscovery
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1,
+// usable as CA, server and client certificate, as ca.pem, cert.pem and
+// key.pem in dir.
+func writeTestCertificate(t *testing.T, dir string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "docker"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "ca.pem"), certPEM, 0o600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0o600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func TestTLSEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCertificate(t, dir)
+
+	// A daemon requiring client certificates
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK")
+	}))
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	assert.Nil(t, err)
+	pool := x509.NewCertPool()
+	caPEM, _ := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	pool.AppendCertsFromPEM(caPEM)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	endpoint := "tcp://" + server.Listener.Addr().String()
+
+	c := caddy.NewTestController("dns", `docker `+endpoint+` {
+	tls_ca `+filepath.Join(dir, "ca.pem")+`
+	tls_cert `+filepath.Join(dir, "cert.pem")+`
+	tls_key `+filepath.Join(dir, "key.pem")+`
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Nil(t, dd.dockerClient.Ping())
+
+	// Without a client certificate the daemon refuses the connection
+	c = caddy.NewTestController("dns", `docker `+endpoint+` {
+	tls_ca `+filepath.Join(dir, "ca.pem")+`
+}`)
+	dd, err = createPlugin(c)
+	assert.Nil(t, err)
+	assert.NotNil(t, dd.dockerClient.Ping())
+
+	// Without a CA the daemon's certificate is verified against the system
+	// roots, and isn't verified only when a docker context says so
+	c = caddy.NewTestController("dns", `docker `+endpoint+` {
+	tls_cert `+filepath.Join(dir, "cert.pem")+`
+	tls_key `+filepath.Join(dir, "key.pem")+`
+}`)
+	dd, err = createPlugin(c)
+	assert.Nil(t, err)
+	assert.NotNil(t, dd.dockerClient.Ping())
+	client, err := newDockerClient(endpoint, &dockerTLSFiles{cert: filepath.Join(dir, "cert.pem"), key: filepath.Join(dir, "key.pem"), skipVerify: true})
+	assert.Nil(t, err)
+	assert.Nil(t, client.Ping())
+
+	// Endpoints can have their own certificate directory, instead of the
+	// TLS files of the plugin
+	otherDir := t.TempDir()
+	writeTestCertificate(t, otherDir)
+	c = caddy.NewTestController("dns", `docker unix:///var/run/docker.sock {
+	tls_ca `+filepath.Join(otherDir, "ca.pem")+`
+	tls_cert `+filepath.Join(otherDir, "cert.pem")+`
+	tls_key `+filepath.Join(otherDir, "key.pem")+`
+	endpoint node2 `+endpoint+` 192.168.1.12 tls `+dir+`
+	endpoint node3 `+endpoint+`
+	endpoint node4 `+endpoint+` tls `+dir+`
+}`)
+	dd, err = createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.1.12", dd.endpoints[0].target.a.String())
+	assert.Nil(t, dd.endpoints[0].client.Ping())
+	assert.NotNil(t, dd.endpoints[1].client.Ping())
+	assert.Nil(t, dd.endpoints[2].target)
+	assert.Nil(t, dd.endpoints[2].client.Ping())
+
+	for _, config := range []string{
+		"tls_cert " + filepath.Join(dir, "cert.pem"),
+		"tls_ca " + filepath.Join(dir, "missing.pem"),
+		"tls_key",
+		"endpoint node2 " + endpoint + " tls " + filepath.Join(dir, "missing"),
+		"endpoint node2 " + endpoint + " 192.168.1.12 tls",
+	} {
+		c = caddy.NewTestController("dns", "docker "+endpoint+" {\n\t"+config+"\n}")
+		_, err = createPlugin(c)
+		assert.NotNil(t, err, config)
+	}
+}
+
+func TestEndpointFromEnv(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", configDir)
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "")
+	t.Setenv("DOCKER_TLS_VERIFY", "")
+
+	endpoint, tlsFiles, err := endpointFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, "", endpoint)
+	assert.Nil(t, tlsFiles)
+
+	// Current context of the CLI configuration
+	writeContext := func(name string, host string, skipTLSVerify bool) string {
+		digest := sha256.Sum256([]byte(name))
+		id := hex.EncodeToString(digest[:])
+		metaDir := filepath.Join(configDir, "contexts", "meta", id)
+		assert.Nil(t, os.MkdirAll(metaDir, 0o700))
+		assert.Nil(t, os.WriteFile(filepath.Join(metaDir, "meta.json"),
+			[]byte(`{"Name":"`+name+`","Endpoints":{"docker":{"Host":"`+host+`","SkipTLSVerify":`+strconv.FormatBool(skipTLSVerify)+`}}}`), 0o600))
+		return filepath.Join(configDir, "contexts", "tls", id, "docker")
+	}
+	writeContext("node2", "ssh://admin@node2.lan", false)
+	tlsDir := writeContext("node3", "tcp://node3.lan:2376", false)
+	assert.Nil(t, os.MkdirAll(tlsDir, 0o700))
+	insecureDir := writeContext("node4", "tcp://node4.lan:2376", true)
+	assert.Nil(t, os.MkdirAll(insecureDir, 0o700))
+	assert.Nil(t, os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"currentContext":"node2"}`), 0o600))
+
+	endpoint, tlsFiles, err = endpointFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, "ssh://admin@node2.lan", endpoint)
+	assert.Nil(t, tlsFiles)
+
+	// DOCKER_CONTEXT takes precedence, with the TLS files of the context
+	t.Setenv("DOCKER_CONTEXT", "node3")
+	endpoint, tlsFiles, err = endpointFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, "tcp://node3.lan:2376", endpoint)
+	assert.Equal(t, &dockerTLSFiles{ca: filepath.Join(tlsDir, "ca.pem"), cert: filepath.Join(tlsDir, "cert.pem"), key: filepath.Join(tlsDir, "key.pem")}, tlsFiles)
+
+	// Only contexts skip the verification of the daemon's certificate
+	t.Setenv("DOCKER_CONTEXT", "node4")
+	_, tlsFiles, err = endpointFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, &dockerTLSFiles{cert: filepath.Join(insecureDir, "cert.pem"), key: filepath.Join(insecureDir, "key.pem"), skipVerify: true}, tlsFiles)
+
+	t.Setenv("DOCKER_CONTEXT", "missing")
+	_, _, err = endpointFromEnv()
+	assert.NotNil(t, err)
+
+	// DOCKER_HOST takes precedence over contexts
+	t.Setenv("DOCKER_HOST", "tcp://10.0.0.1:2376")
+	t.Setenv("DOCKER_TLS_VERIFY", "1")
+	t.Setenv("DOCKER_CERT_PATH", "/etc/docker/certs")
+	endpoint, tlsFiles, err = endpointFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, "tcp://10.0.0.1:2376", endpoint)
+	assert.Equal(t, &dockerTLSFiles{ca: "/etc/docker/certs/ca.pem", cert: "/etc/docker/certs/cert.pem", key: "/etc/docker/certs/key.pem"}, tlsFiles)
+
+	// Used without an endpoint argument only
+	t.Setenv("DOCKER_TLS_VERIFY", "")
+	c := caddy.NewTestController("dns", `docker`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "tcp://10.0.0.1:2376", dd.dockerEndpoint)
+	c = caddy.NewTestController("dns", `docker unix:///home/user/docker.sock`)
+	dd, err = createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "unix:///home/user/docker.sock", dd.dockerEndpoint)
+}
+
+func TestSSHEndpoint(t *testing.T) {
+	dialer, err := newSSHDialer("ssh://admin@node2.lan:2222")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-l", "admin", "-p", "2222", "--", "node2.lan", "docker", "system", "dial-stdio"}, dialer.args)
+	dialer, err = newSSHDialer("ssh://node2.lan")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"--", "node2.lan", "docker", "system", "dial-stdio"}, dialer.args)
+
+	for _, endpoint := range []string{"ssh://", "ssh://node2.lan/var/run/docker.sock"} {
+		_, err = newSSHDialer(endpoint)
+		assert.NotNil(t, err, endpoint)
+	}
+
+	client, err := newDockerClient("ssh://admin@node2.lan", nil)
+	assert.Nil(t, err)
+	assert.IsType(t, &sshDialer{}, client.Dialer)
+}
+
+func TestCommandConn(t *testing.T) {
+	conn, err := dialCommand("cat")
+	if err != nil {
+		t.Skip("cat not available:", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	assert.Nil(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(buf))
+	assert.Nil(t, conn.Close())
+	assert.Nil(t, conn.Close())
+}
+
+func TestSSHDialerContext(t *testing.T) {
+	dir := t.TempDir()
+	// An ssh stand-in echoing its input
+	script := filepath.Join(dir, "ssh")
+	assert.Nil(t, os.WriteFile(script, []byte("#!/bin/sh\nexec cat\n"), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dialer, err := newSSHDialer("ssh://node2.lan")
+	assert.Nil(t, err)
+
+	// The connection outlives the context of the request it was dialed for
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := dialer.DialContext(ctx, "tcp", "docker.ssh:2375")
+	cancel()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	_, err = conn.Write([]byte("ping"))
+	assert.Nil(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	// Canceled contexts don't dial
+	_, err = dialer.DialContext(ctx, "tcp", "docker.ssh:2375")
+	assert.NotNil(t, err)
+}
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/request"
@@ -22,6 +25,33 @@ type ContainerInfo struct {
 	domains          []string // resolved domains (A/AAAA records)
 	cnameDomains     []string // domains resolved via traefik labels (CNAME records)
 	tunnelServiceURL string   // if set, use tunnel routes instead of DNS CNAME
+	source           string   // name of the endpoint of the container, empty for the primary one
+
+	// Targets of cnameDomains that don't use the default target
+	// (traefik_cname/traefik_a), e.g. hosts of Traefik TCP routers.
+	cnameTargets map[string]recordTarget
+}
+
+// recordTarget is what a CNAME domain resolves to: a CNAME to a hostname,
+// or, when no hostname is set, an A record with a fixed address.
+type recordTarget struct {
+	cname string
+	a     net.IP
+}
+
+// parseRecordTarget reads a target given as either an IP address or a hostname.
+func parseRecordTarget(s string) recordTarget {
+	if ip := net.ParseIP(s); ip != nil {
+		return recordTarget{a: ip}
+	}
+	return recordTarget{cname: s}
+}
+
+// proxyResolver is a resolver of the hosts served by a reverse proxy other
+// than Traefik (e.g. caddy-docker-proxy), and the target of their records.
+type proxyResolver struct {
+	resolver ContainerDomainResolver
+	target   recordTarget
 }
 
 type ContainerInfoMap map[string]*ContainerInfo
@@ -38,19 +68,86 @@ type DockerDiscovery struct {
 	resolvers      []ContainerDomainResolver
 	dockerClient   *dockerapi.Client
 
+	// Work called for by events, serialized per container (or per Swarm
+	// and pod sync).
+	queue *keyedQueue
+
+	// Docker hosts watched besides the primary endpoint (endpoint).
+	endpoints []*dockerEndpoint
+
+	// Interval of the resyncs of every endpoint with Docker (resync), zero
+	// when disabled.
+	resyncInterval time.Duration
+
+	// Names of the endpoints whose containers are published and whose
+	// events are followed, the primary one named "" (Ready). scanned is
+	// closed once all of them were.
+	connectedMutex sync.RWMutex
+	connected      map[string]bool
+	scanned        chan struct{}
+	scannedOnce    sync.Once
+
+	// Queries for holdZones wait for the first scan of every endpoint, up
+	// to holdTimeout (wait_ready). Zero when disabled.
+	holdTimeout time.Duration
+	holdZones   []string
+
 	mutex            sync.RWMutex
 	containerInfoMap ContainerInfoMap
 	ttl              uint32
 
+	// Namespace of every label the plugin reads, e.g. <labelPrefix>.host
+	labelPrefix string
+
 	// Resolvers whose results produce CNAME records (e.g. cname_target, traefik labels).
 	cnameResolvers []ContainerDomainResolver
 
+	// Ordered name_rewrite rules applied to every resolved name.
+	nameRewrites []*NameRewriteRule
+
+	// Decides which containers are published at all (expose_by_default,
+	// include_label, exclude_image, include_network, include_compose_project).
+	filter *ContainerFilter
+
 	// Traefik label support: when set, domains from TraefikLabelResolver
 	// produce CNAME or A records pointing to the configured target.
 	traefikResolver *TraefikLabelResolver
 	traefikCNAME    string // CNAME target for traefik-discovered hosts
 	traefikA        net.IP // A record target for traefik-discovered hosts
 
+	// Target for hosts of Traefik TCP/UDP routers (traefik_tcp_cname,
+	// traefik_tcp_a). Nil means they use the HTTP target above.
+	traefikTCPTarget *recordTarget
+
+	// Targets of hosts of routers on specific Traefik entrypoints
+	// (traefik_entrypoint), taking precedence over the targets above.
+	traefikEntrypointTargets map[string]recordTarget
+
+	// Traefik file provider (traefik_file): hosts of the routers declared
+	// there are published like those of Traefik labels.
+	traefikFile *TraefikFileProvider
+
+	// Traefik API polling (traefik_api): hosts of the routers of every
+	// Traefik provider, as listed by Traefik itself.
+	traefikAPI *TraefikAPIProvider
+
+	// Swarm mode (swarm): services are published as <service>.<swarmDomain>
+	// and task containers aren't published on their own.
+	swarmDomain string
+	swarmMutex  sync.Mutex // serializes Swarm syncs
+
+	// Podman pods (podman_pods): pods are published as <pod>.<podDomain>
+	// and their infra containers aren't published on their own.
+	podDomain     string
+	podMutex      sync.Mutex // serializes pod syncs
+	noLibpod      bool       // the endpoint has no libpod API, guarded by podMutex
+	podInfraMutex sync.RWMutex
+	podInfraIDs   map[string]bool
+
+	// Hosts of other reverse proxies (caddy_cname, caddy_a), published as
+	// CNAME domains pointing to their proxy.
+	proxyResolvers []proxyResolver
+
 	// Cloudflare DNS sync: when configured, CNAME records are synced
 	// to Cloudflare whenever containers start/stop.
 	cloudflareSyncer *CloudflareSyncer
@@ -67,13 +164,71 @@ func NewDockerDiscovery(dockerEndpoint string) *DockerDiscovery {
 	return &DockerDiscovery{
 		dockerEndpoint:   dockerEndpoint,
 		containerInfoMap: make(ContainerInfoMap),
+		queue:            newKeyedQueue(),
+		connected:        make(map[string]bool),
+		scanned:          make(chan struct{}),
 		ttl:              3600,
+		labelPrefix:      defaultLabelPrefix,
+		filter:           NewContainerFilter(),
+	}
+}
+
+// label returns the full name of one of the plugin's container labels,
+// e.g. label("host") is "coredns.dockerdiscovery.host" by default.
+func (dd *DockerDiscovery) label(name string) string {
+	return dd.labelPrefix + "." + name
+}
+
+// defaultTarget is the target of CNAME domains without a specific one.
+func (dd *DockerDiscovery) defaultTarget() recordTarget {
+	return recordTarget{cname: dd.traefikCNAME, a: dd.traefikA}
+}
+
+// hasDefaultTarget reports whether a default target is configured
+// (traefik_cname, traefik_a, or cf_target and tunnels, which imply one).
+// The Traefik resolver can be enabled without one, by targets of TCP
+// routers or entrypoints only.
+func (dd *DockerDiscovery) hasDefaultTarget() bool {
+	return dd.traefikCNAME != "" || dd.traefikA != nil
+}
+
+// traefikTarget returns the target of a Traefik host, if it doesn't use the
+// default one: the target of the first of its router's entrypoints with a
+// traefik_entrypoint mapping, else the TCP target for TCP and UDP routers.
+func (dd *DockerDiscovery) traefikTarget(host traefikHost) (recordTarget, bool) {
+	for _, entrypoint := range host.entrypoints {
+		if target, ok := dd.traefikEntrypointTargets[entrypoint]; ok {
+			return target, true
+		}
+	}
+	if host.protocol != "http" && dd.traefikTCPTarget != nil {
+		return *dd.traefikTCPTarget, true
 	}
+	return recordTarget{}, false
 }
 
-func (dd *DockerDiscovery) resolveDomainsByContainer(container *dockerapi.Container) ([]string, []string, error) {
+// resolveDomainsByContainer returns the A/AAAA domains and CNAME domains of
+// a container, and the targets of CNAME domains not using the default one.
+func (dd *DockerDiscovery) resolveDomainsByContainer(container *dockerapi.Container) ([]string, []string, map[string]recordTarget, error) {
 	var domains []string
 	var cnameDomains []string
+	targets := make(map[string]recordTarget)
+
+	if !dd.filter.allows(container) {
+		log.Printf("[docker] Container %s (%s) is excluded by filters", normalizeContainerName(container), shortID(container.ID))
+		return domains, cnameDomains, targets, nil
+	}
+
+	// Swarm tasks are published through their service
+	if dd.swarmDomain != "" && container.Config != nil && container.Config.Labels[swarmTaskLabel] != "" {
+		return domains, cnameDomains, targets, nil
+	}
+
+	// Pod infra containers are published through their pod
+	if dd.isPodInfra(container.ID) {
+		return domains, cnameDomains, targets, nil
+	}
+
 	for _, resolver := range dd.resolvers {
 		var d, err = resolver.resolve(container)
 		if err != nil {
@@ -93,20 +248,50 @@ func (dd *DockerDiscovery) resolveDomainsByContainer(container *dockerapi.Contai
 
 	// Resolve traefik label domains separately
 	if dd.traefikResolver != nil {
-		d, err := dd.traefikResolver.resolve(container)
+		for _, host := range dd.traefikResolver.resolveHosts(container) {
+			cnameDomains = append(cnameDomains, host.name)
+			if target, ok := dd.traefikTarget(host); ok {
+				targets[host.name] = target
+			}
+		}
+	}
+
+	for _, proxy := range dd.proxyResolvers {
+		d, err := proxy.resolver.resolve(container)
 		if err != nil {
-			log.Printf("[docker] Error resolving traefik label domains %s", err)
+			log.Printf("[docker] Error resolving proxy label domains %s", err)
+		}
+		for _, name := range d {
+			cnameDomains = append(cnameDomains, name)
+			targets[name] = proxy.target
 		}
-		cnameDomains = append(cnameDomains, d...)
 	}
 
-	return domains, cnameDomains, nil
+	cnameDomains, targets = dd.rewriteCNAMEDomains(cnameDomains, targets)
+	return rewriteNames(dd.nameRewrites, domains), cnameDomains, targets, nil
+}
+
+// rewriteCNAMEDomains applies the name_rewrite rules to CNAME domains one
+// at a time, so each rewritten name keeps the target of its original.
+func (dd *DockerDiscovery) rewriteCNAMEDomains(domains []string, targets map[string]recordTarget) ([]string, map[string]recordTarget) {
+	var rewrittenDomains []string
+	rewrittenTargets := make(map[string]recordTarget)
+	for _, d := range domains {
+		for _, r := range rewriteNames(dd.nameRewrites, []string{d}) {
+			rewrittenDomains = append(rewrittenDomains, r)
+			if target, ok := targets[d]; ok {
+				rewrittenTargets[r] = target
+			}
+		}
+	}
+	return rewrittenDomains, rewrittenTargets
 }
 
 // DomainLookupResult holds the result of a domain lookup with record type info
 type DomainLookupResult struct {
 	containerInfo *ContainerInfo
-	isCNAME       bool // true if this domain should return CNAME/traefik-A records
+	isCNAME       bool         // true if this domain should return CNAME/traefik-A records
+	target        recordTarget // what a CNAME domain points to
 }
 
 func (dd *DockerDiscovery) containerInfoByDomain(requestName string) (*DomainLookupResult, error) {
@@ -119,61 +304,157 @@ func (dd *DockerDiscovery) containerInfoByDomain(requestName string) (*DomainLoo
 	// Example: container_name "traefik" + domain "177cpt.com" would create
 	// an A record for traefik.177cpt.com pointing to the container IP,
 	// shadowing the intended CNAME from traefik_cname.
+	// Exact names are preferred over wildcards, and longer (more specific)
+	// wildcards over shorter ones.
+	// Names claimed on several endpoints resolve as on the first one.
+	var exactCNAME, wildcardCNAME, wildcardA *DomainLookupResult
+	var wildcardCNAMELen, wildcardALen int
 	for _, containerInfo := range dd.containerInfoMap {
 		for _, d := range containerInfo.cnameDomains {
-			if fmt.Sprintf("%s.", d) == requestName {
-				return &DomainLookupResult{containerInfo: containerInfo, isCNAME: true}, nil
+			match := matchDomain(d, requestName)
+			if match == domainMatchNone || (match == domainMatchWildcard && len(d) <= wildcardCNAMELen) {
+				continue
+			}
+			target, ok := containerInfo.cnameTargets[d]
+			if !ok {
+				target = dd.defaultTarget()
 			}
+			result := &DomainLookupResult{containerInfo: containerInfo, isCNAME: true, target: target}
+			if match == domainMatchExact {
+				if exactCNAME == nil || dd.sourceRank(containerInfo.source) < dd.sourceRank(exactCNAME.containerInfo.source) {
+					exactCNAME = result
+				}
+				continue
+			}
+			wildcardCNAME, wildcardCNAMELen = result, len(d)
 		}
 	}
+	if exactCNAME != nil {
+		return exactCNAME, nil
+	}
 
 	for _, containerInfo := range dd.containerInfoMap {
 		for _, d := range containerInfo.domains {
-			if fmt.Sprintf("%s.", d) == requestName {
+			match := matchDomain(d, requestName)
+			if match == domainMatchExact {
 				return &DomainLookupResult{containerInfo: containerInfo, isCNAME: false}, nil
 			}
+			if match == domainMatchWildcard && len(d) > wildcardALen {
+				wildcardA, wildcardALen = &DomainLookupResult{containerInfo: containerInfo, isCNAME: false}, len(d)
+			}
 		}
 	}
 
-	return nil, nil
+	if wildcardCNAME != nil {
+		return wildcardCNAME, nil
+	}
+	return wildcardA, nil
+}
+
+// addressesByDomain returns the addresses of every container that has
+// requestName as an A/AAAA domain, so names shared by several containers
+// (e.g. the replicas of a compose service) resolve to all of them. Names
+// only covered by wildcards resolve to the containers of the most
+// specific wildcard.
+func (dd *DockerDiscovery) addressesByDomain(requestName string, v6 bool) []net.IP {
+	dd.mutex.RLock()
+	defer dd.mutex.RUnlock()
+
+	var addresses, wildcardAddresses []net.IP
+	var wildcardLen int
+	for _, containerInfo := range dd.containerInfoMap {
+		address := containerInfo.address
+		if v6 {
+			address = containerInfo.address6
+		}
+		if address == nil {
+			continue
+		}
+		for _, d := range containerInfo.domains {
+			switch matchDomain(d, requestName) {
+			case domainMatchExact:
+				addresses = appendUniqueIP(addresses, address)
+			case domainMatchWildcard:
+				if len(d) > wildcardLen {
+					wildcardAddresses, wildcardLen = nil, len(d)
+				}
+				if len(d) == wildcardLen {
+					wildcardAddresses = appendUniqueIP(wildcardAddresses, address)
+				}
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return wildcardAddresses
+	}
+	return addresses
+}
+
+func appendUniqueIP(ips []net.IP, ip net.IP) []net.IP {
+	for _, existing := range ips {
+		if existing.Equal(ip) {
+			return ips
+		}
+	}
+	return append(ips, ip)
+}
+
+const (
+	domainMatchNone = iota
+	domainMatchExact
+	domainMatchWildcard
+)
+
+// matchDomain reports how a domain (without trailing dot) matches the
+// requested FQDN: exactly, through its leading "*." wildcard label (which,
+// like a DNS wildcard, covers any depth of subdomains), or not at all.
+func matchDomain(domain string, requestName string) int {
+	if domain+"." == requestName {
+		return domainMatchExact
+	}
+	if strings.HasPrefix(domain, "*.") && strings.HasSuffix(requestName, domain[1:]+".") {
+		return domainMatchWildcard
+	}
+	return domainMatchNone
 }
 
 // ServeDNS implements plugin.Handler
 func (dd *DockerDiscovery) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	state := request.Request{W: w, Req: r}
+	dd.holdQuery(ctx, state.Name())
 	var answers []dns.RR
 	switch state.QType() {
 	case dns.TypeA:
 		result, _ := dd.containerInfoByDomain(state.QName())
 		if result != nil && result.isCNAME {
-			if dd.traefikCNAME != "" {
+			if result.target.cname != "" {
 				// Return CNAME record pointing to the traefik server
-				answers = getCNAMEAnswer(state.Name(), dd.traefikCNAME, dd.ttl)
+				answers = getCNAMEAnswer(state.Name(), result.target.cname, dd.ttl)
 				// Chase the CNAME: resolve the target through the plugin chain
 				// so the client gets both CNAME + A in one response
-				if extra := dd.chaseCNAME(ctx, w, dd.traefikCNAME, dns.TypeA); extra != nil {
+				if extra := dd.chaseCNAME(ctx, w, result.target.cname, dns.TypeA); extra != nil {
 					answers = append(answers, extra...)
 				}
-			} else if dd.traefikA != nil {
+			} else if result.target.a != nil {
 				// Return A record with the configured traefik IP
-				answers = getAnswer(state.Name(), []net.IP{dd.traefikA}, dd.ttl, false)
+				answers = getAnswer(state.Name(), []net.IP{result.target.a}, dd.ttl, false)
 			}
 		} else if result != nil {
-			answers = getAnswer(state.Name(), []net.IP{result.containerInfo.address}, dd.ttl, false)
+			answers = getAnswer(state.Name(), dd.addressesByDomain(state.QName(), false), dd.ttl, false)
 		}
 	case dns.TypeAAAA:
 		result, _ := dd.containerInfoByDomain(state.QName())
 		if result != nil && result.isCNAME {
 			// For CNAME/traefik domains, return the CNAME for AAAA queries too
-			if dd.traefikCNAME != "" {
-				answers = getCNAMEAnswer(state.Name(), dd.traefikCNAME, dd.ttl)
-				if extra := dd.chaseCNAME(ctx, w, dd.traefikCNAME, dns.TypeAAAA); extra != nil {
+			if result.target.cname != "" {
+				answers = getCNAMEAnswer(state.Name(), result.target.cname, dd.ttl)
+				if extra := dd.chaseCNAME(ctx, w, result.target.cname, dns.TypeAAAA); extra != nil {
 					answers = append(answers, extra...)
 				}
 			}
 			// For traefik_a mode, we don't return AAAA records (IPv4 only)
-		} else if result != nil && result.containerInfo.address6 != nil {
-			answers = getAnswer(state.Name(), []net.IP{result.containerInfo.address6}, dd.ttl, true)
+		} else if addresses6 := dd.addressesByDomain(state.QName(), true); result != nil && len(addresses6) > 0 {
+			answers = getAnswer(state.Name(), addresses6, dd.ttl, true)
 		} else if result != nil && result.containerInfo.address != nil {
 			// Per RFC 6147 section 5.1.2: return a NODATA response (empty answer
 			// section with NOERROR rcode) when no AAAA records are available but
@@ -190,8 +471,8 @@ func (dd *DockerDiscovery) ServeDNS(ctx context.Context, w dns.ResponseWriter, r
 		}
 	case dns.TypeCNAME:
 		result, _ := dd.containerInfoByDomain(state.QName())
-		if result != nil && result.isCNAME && dd.traefikCNAME != "" {
-			answers = getCNAMEAnswer(state.Name(), dd.traefikCNAME, dd.ttl)
+		if result != nil && result.isCNAME && result.target.cname != "" {
+			answers = getCNAMEAnswer(state.Name(), result.target.cname, dd.ttl)
 		}
 	}
 
@@ -219,10 +500,17 @@ func (dd *DockerDiscovery) Name() string {
 }
 
 func (dd *DockerDiscovery) getContainerAddress(container *dockerapi.Container, v6 bool) (net.IP, error) {
+	return dd.getEndpointContainerAddress(dd.primaryEndpoint(), container, v6)
+}
+
+// getEndpointContainerAddress returns the address of a container of the
+// endpoint, inspecting the containers whose network namespace it shares
+// through the endpoint.
+func (dd *DockerDiscovery) getEndpointContainerAddress(ep *dockerEndpoint, container *dockerapi.Container, v6 bool) (net.IP, error) {
 
 	// Allow explicit IP override via label
 	if !v6 {
-		if addrStr, ok := container.Config.Labels["coredns.dockerdiscovery.address"]; ok && addrStr != "" {
+		if addrStr, ok := container.Config.Labels[dd.label("address")]; ok && addrStr != "" {
 			if ip := net.ParseIP(addrStr); ip != nil && ip.To4() != nil {
 				return ip, nil
 			}
@@ -230,7 +518,7 @@ func (dd *DockerDiscovery) getContainerAddress(container *dockerapi.Container, v
 	}
 
 	// save this away
-	netName, hasNetName := container.Config.Labels["coredns.dockerdiscovery.network"]
+	netName, hasNetName := container.Config.Labels[dd.label("network")]
 
 	var networkMode string
 
@@ -255,7 +543,7 @@ func (dd *DockerDiscovery) getContainerAddress(container *dockerapi.Container, v
 			log.Printf("Container %s is in another container's network namspace", container.ID[:12])
 			otherID := container.HostConfig.NetworkMode[len("container:"):]
 			var err error
-			container, err = dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: otherID})
+			container, err = ep.client.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: otherID})
 			if err != nil {
 				return nil, err
 			}
@@ -294,26 +582,96 @@ func (dd *DockerDiscovery) getContainerAddress(container *dockerapi.Container, v
 }
 
 func (dd *DockerDiscovery) updateContainerInfo(container *dockerapi.Container) error {
+	return dd.updateEndpointContainerInfo(dd.primaryEndpoint(), container)
+}
+
+// updateEndpointContainerInfo (re)publishes a container of the endpoint.
+func (dd *DockerDiscovery) updateEndpointContainerInfo(ep *dockerEndpoint, container *dockerapi.Container) error {
 	dd.mutex.Lock()
 	defer dd.mutex.Unlock()
 
-	_, isExist := dd.containerInfoMap[container.ID]
+	key := ep.key(container.ID)
+	previous, isExist := dd.containerInfoMap[key]
 	if isExist { // remove previous resolved container info
-		delete(dd.containerInfoMap, container.ID)
+		delete(dd.containerInfoMap, key)
+	}
+
+	containerInfo := dd.resolveContainerInfo(ep, container)
+	if containerInfo != nil {
+		dd.warnConflicts(ep.name, containerInfo.cnameDomains)
+		dd.containerInfoMap[key] = containerInfo
+
+		// Hosts the container doesn't publish anymore, e.g. after a rename
+		if isExist {
+			if dropped := droppedCNAMEDomains(previous, containerInfo); len(dropped) > 0 {
+				log.Printf("[docker] Remove CNAME entries of container %s (%s): %v", normalizeContainerName(container), container.ID[:12], dropped)
+				dd.removeFromCloudflare(&ContainerInfo{cnameDomains: dropped, tunnelServiceURL: previous.tunnelServiceURL})
+			}
+		}
+
+		if !isExist {
+			if containerInfo.address != nil {
+				log.Printf("[docker] Add entry of container %s (%s). IP: %v", normalizeContainerName(container), container.ID[:12], containerInfo.address)
+			}
+			if len(containerInfo.cnameDomains) > 0 {
+				log.Printf("[docker] Add CNAME entries for container %s (%s): %v", normalizeContainerName(container), container.ID[:12], containerInfo.cnameDomains)
+			}
+		}
+
+		// Sync to Cloudflare: tunnel routes or DNS CNAME (mutually exclusive)
+		cnameDomains, tunnelServiceURL := containerInfo.cnameDomains, containerInfo.tunnelServiceURL
+		if dd.tunnelSyncer != nil && tunnelServiceURL != "" && len(cnameDomains) > 0 {
+			go dd.tunnelSyncer.AddRoutes(cnameDomains, tunnelServiceURL)
+		} else if dd.cloudflareSyncer != nil && len(cnameDomains) > 0 {
+			go dd.cloudflareSyncer.SyncDomains(cnameDomains)
+		}
+	} else if isExist {
+		log.Printf("[docker] Remove container entry %s (%s)", normalizeContainerName(container), container.ID[:12])
+		dd.removeFromCloudflare(previous)
 	}
+	return nil
+}
 
+// resolveContainerInfo returns the entry of a container of the endpoint, or
+// nil when it has no domains.
+func (dd *DockerDiscovery) resolveContainerInfo(ep *dockerEndpoint, container *dockerapi.Container) *ContainerInfo {
 	// Resolve domains FIRST — CNAME domains (traefik labels) don't need an IP
-	domains, cnameDomains, _ := dd.resolveDomainsByContainer(container)
+	domains, cnameDomains, cnameTargets, _ := dd.resolveDomainsByContainer(container)
 
 	// Try to get the container's IP address (needed for A/AAAA records only)
-	containerAddress, err := dd.getContainerAddress(container, false)
+	containerAddress, err := dd.getEndpointContainerAddress(ep, container, false)
 	if err != nil {
 		log.Printf("[docker] Could not resolve IP for container %s (%s): %s", normalizeContainerName(container), container.ID[:12], err)
 	}
 
 	var containerAddress6 net.IP
 	if containerAddress != nil {
-		containerAddress6, _ = dd.getContainerAddress(container, true)
+		containerAddress6, _ = dd.getEndpointContainerAddress(ep, container, true)
+	}
+
+	// Containers of other hosts are reached through their host
+	if ep.target != nil {
+		if ep.target.a != nil {
+			containerAddress, containerAddress6 = ep.target.a, nil
+		}
+		for _, d := range cnameDomains {
+			if _, ok := cnameTargets[d]; !ok {
+				cnameTargets[d] = *ep.target
+			}
+		}
+	}
+
+	// Without a default target, CNAME domains need a specific one, e.g.
+	// hosts of routers on unmapped entrypoints when only traefik_entrypoint
+	// is set
+	if !dd.hasDefaultTarget() && len(cnameDomains) > 0 {
+		var targeted []string
+		for _, d := range cnameDomains {
+			if _, ok := cnameTargets[d]; ok {
+				targeted = append(targeted, d)
+			}
+		}
+		cnameDomains = targeted
 	}
 
 	// If we have no IP, we can't serve A/AAAA records for regular domains
@@ -322,52 +680,112 @@ func (dd *DockerDiscovery) updateContainerInfo(container *dockerapi.Container) e
 		domains = nil
 	}
 
-	if len(domains) > 0 || len(cnameDomains) > 0 {
-		dd.containerInfoMap[container.ID] = &ContainerInfo{
-			container:    container,
-			address:      containerAddress,
-			address6:     containerAddress6,
-			domains:      domains,
-			cnameDomains: cnameDomains,
-		}
+	if len(domains) == 0 && len(cnameDomains) == 0 {
+		return nil
+	}
 
-		if !isExist {
-			if containerAddress != nil {
-				log.Printf("[docker] Add entry of container %s (%s). IP: %v", normalizeContainerName(container), container.ID[:12], containerAddress)
-			}
-			if len(cnameDomains) > 0 {
-				log.Printf("[docker] Add CNAME entries for container %s (%s): %v", normalizeContainerName(container), container.ID[:12], cnameDomains)
+	// Check for tunnel label — if present, use tunnel routes instead of DNS
+	var tunnelServiceURL string
+	if dd.tunnelSyncer != nil && container.Config != nil {
+		if labelVal, ok := container.Config.Labels[dd.label("cf_tunnel")]; ok {
+			if labelVal != "" && labelVal != "true" {
+				tunnelServiceURL = labelVal
+			} else {
+				// Derive from Traefik service port label
+				port := getTraefikServicePort(container.Config.Labels)
+				if port == "" && dd.traefikResolver != nil && dd.traefikResolver.v1 {
+					port = getTraefikV1Port(container.Config.Labels)
+				}
+				if port != "" {
+					tunnelServiceURL = "http://localhost:" + port
+				} else {
+					log.Printf("[docker] Container %s has cf_tunnel label but no service URL or Traefik port", container.ID[:12])
+				}
 			}
 		}
+	}
 
-		// Check for tunnel label — if present, use tunnel routes instead of DNS
-		var tunnelServiceURL string
-		if dd.tunnelSyncer != nil && container.Config != nil {
-			if labelVal, ok := container.Config.Labels["coredns.dockerdiscovery.cf_tunnel"]; ok {
-				if labelVal != "" && labelVal != "true" {
-					tunnelServiceURL = labelVal
-				} else {
-					// Derive from Traefik service port label
-					if port := getTraefikServicePort(container.Config.Labels); port != "" {
-						tunnelServiceURL = "http://localhost:" + port
-					} else {
-						log.Printf("[docker] Container %s has cf_tunnel label but no service URL or Traefik port", container.ID[:12])
-					}
-				}
+	return &ContainerInfo{
+		container:        container,
+		address:          containerAddress,
+		address6:         containerAddress6,
+		domains:          domains,
+		cnameDomains:     cnameDomains,
+		cnameTargets:     cnameTargets,
+		tunnelServiceURL: tunnelServiceURL,
+		source:           ep.name,
+	}
+}
+
+// syncExternalEntries publishes domains not discovered from containers
+// (e.g. in Traefik's file provider). Entries are keyed like containers, and
+// the ones whose key starts with prefix are replaced by entries; a nil
+// ContainerInfo keeps the previous entry of its key. Cloudflare gets the
+// domains that were added or removed.
+func (dd *DockerDiscovery) syncExternalEntries(prefix string, entries map[string]*ContainerInfo) {
+	dd.mutex.Lock()
+	defer dd.mutex.Unlock()
+
+	previous := make(map[string]bool)
+	for key, containerInfo := range dd.containerInfoMap {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for _, d := range containerInfo.cnameDomains {
+			previous[d] = true
+		}
+		if entry, ok := entries[key]; !ok || entry != nil {
+			delete(dd.containerInfoMap, key)
+		}
+	}
+
+	for key, entry := range entries {
+		if entry == nil || len(entry.domains) == 0 && len(entry.cnameDomains) == 0 {
+			continue
+		}
+		if entry.container == nil {
+			// Synthetic container, so lookups and logging treat the entry as any other
+			entry.container = &dockerapi.Container{ID: key, Name: key, Config: &dockerapi.Config{}}
+		}
+		dd.containerInfoMap[key] = entry
+	}
+
+	current := make(map[string]bool)
+	for key, containerInfo := range dd.containerInfoMap {
+		if strings.HasPrefix(key, prefix) {
+			for _, d := range containerInfo.cnameDomains {
+				current[d] = true
 			}
 		}
-		dd.containerInfoMap[container.ID].tunnelServiceURL = tunnelServiceURL
+	}
+	var added, removed []string
+	for d := range current {
+		if !previous[d] {
+			added = append(added, d)
+		}
+	}
+	for d := range previous {
+		if !current[d] {
+			removed = append(removed, d)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	if len(added) > 0 {
+		log.Printf("[docker] Add CNAME entries from %s: %v", strings.TrimSuffix(prefix, ":"), added)
+	}
+	if len(removed) > 0 {
+		log.Printf("[docker] Remove CNAME entries from %s: %v", strings.TrimSuffix(prefix, ":"), removed)
+	}
 
-		// Sync to Cloudflare: tunnel routes or DNS CNAME (mutually exclusive)
-		if dd.tunnelSyncer != nil && tunnelServiceURL != "" && len(cnameDomains) > 0 {
-			go dd.tunnelSyncer.AddRoutes(cnameDomains, tunnelServiceURL)
-		} else if dd.cloudflareSyncer != nil && len(cnameDomains) > 0 {
-			go dd.cloudflareSyncer.SyncDomains(cnameDomains)
+	if dd.cloudflareSyncer != nil {
+		if len(added) > 0 {
+			go dd.cloudflareSyncer.SyncDomains(added)
+		}
+		if len(removed) > 0 {
+			go dd.cloudflareSyncer.RemoveDomains(removed)
 		}
-	} else if isExist {
-		log.Printf("[docker] Remove container entry %s (%s)", normalizeContainerName(container), container.ID[:12])
 	}
-	return nil
 }
 
 func (dd *DockerDiscovery) removeContainerInfo(containerID string) error {
@@ -379,7 +797,34 @@ func (dd *DockerDiscovery) removeContainerInfo(containerID string) error {
 		log.Printf("[docker] No entry associated with the container %s", shortID(containerID))
 		return nil
 	}
-	// Remove from Cloudflare: tunnel routes or DNS CNAME (mutually exclusive)
+	dd.removeFromCloudflare(containerInfo)
+
+	log.Printf("[docker] Deleting entry %s (%s)", normalizeContainerName(containerInfo.container), shortID(containerInfo.container.ID))
+	delete(dd.containerInfoMap, containerID)
+
+	return nil
+}
+
+// droppedCNAMEDomains returns the CNAME domains of the previous entry of a
+// container that its current entry doesn't have anymore.
+func droppedCNAMEDomains(previous, current *ContainerInfo) []string {
+	kept := make(map[string]bool, len(current.cnameDomains))
+	for _, d := range current.cnameDomains {
+		kept[d] = true
+	}
+	var dropped []string
+	for _, d := range previous.cnameDomains {
+		if !kept[d] {
+			dropped = append(dropped, d)
+		}
+	}
+	return dropped
+}
+
+// removeFromCloudflare removes the Cloudflare records or tunnel routes of an
+// entry going away.
+func (dd *DockerDiscovery) removeFromCloudflare(containerInfo *ContainerInfo) {
+	// Tunnel routes or DNS CNAME (mutually exclusive)
 	if dd.tunnelSyncer != nil && containerInfo.tunnelServiceURL != "" && len(containerInfo.cnameDomains) > 0 {
 		domainsToRemove := make([]string, len(containerInfo.cnameDomains))
 		copy(domainsToRemove, containerInfo.cnameDomains)
@@ -389,20 +834,29 @@ func (dd *DockerDiscovery) removeContainerInfo(containerID string) error {
 		copy(domainsToRemove, containerInfo.cnameDomains)
 		go dd.cloudflareSyncer.RemoveDomains(domainsToRemove)
 	}
+}
 
-	log.Printf("[docker] Deleting entry %s (%s)", normalizeContainerName(containerInfo.container), containerInfo.container.ID[:12])
-	delete(dd.containerInfoMap, containerID)
+// eventBufferSize is the number of Docker events buffered while they wait
+// to be handled.
+const eventBufferSize = 256
 
-	return nil
+// start watches the primary endpoint until stop is closed.
+func (dd *DockerDiscovery) start(primary *dockerEndpoint, stop <-chan struct{}) {
+	log.Println("[docker] start")
+	dd.supervise(primary, stop)
 }
 
-func (dd *DockerDiscovery) start() error {
-	log.Println("[docker] start")
-	log.Printf("[docker] Connecting to Docker endpoint: %s", dd.dockerEndpoint)
+// watch publishes the running containers of the endpoint, removing the
+// entries of containers that stopped since the last scan, then follows its
+// events until the event stream ends or stop is closed. Events missed since
+// the last scan are replayed. Swarm services and Podman pods are only read
+// from the primary endpoint.
+func (dd *DockerDiscovery) watch(ep *dockerEndpoint, stop <-chan struct{}) error {
+	log.Printf("[docker] Connecting to Docker endpoint: %s", ep.describe())
 
 	// Test connectivity first
-	if err := dd.dockerClient.Ping(); err != nil {
-		log.Printf("[docker] ERROR: Cannot ping Docker API at %s: %s", dd.dockerEndpoint, err)
+	if err := ep.client.Ping(); err != nil {
+		log.Printf("[docker] ERROR: Cannot ping Docker API at %s: %s", ep.describe(), err)
 		log.Println("[docker] If using Podman, ensure the Podman socket is enabled:")
 		log.Println("[docker]   rootful: sudo systemctl enable --now podman.socket")
 		log.Println("[docker]   rootless: systemctl --user enable --now podman.socket")
@@ -411,97 +865,254 @@ func (dd *DockerDiscovery) start() error {
 	}
 	log.Println("[docker] Successfully connected to Docker/Podman API")
 
-	events := make(chan *dockerapi.APIEvents)
+	// Buffered: the client drops the events its listeners aren't ready
+	// for, e.g. during the scan
+	events := make(chan *dockerapi.APIEvents, eventBufferSize)
 
-	if err := dd.dockerClient.AddEventListener(events); err != nil {
+	var options dockerapi.EventsOptions
+	if ep.lastEvent > 0 {
+		options.Since = strconv.FormatInt(ep.lastEvent, 10)
+	}
+	if err := ep.client.AddEventListenerWithOptions(options, events); err != nil {
 		log.Printf("[docker] ERROR: Failed to add event listener: %s", err)
 		return err
 	}
+	defer ep.client.RemoveEventListener(events)
 	log.Println("[docker] Event listener registered successfully")
 
-	containers, err := dd.dockerClient.ListContainers(dockerapi.ListContainersOptions{})
+	// Pods first, so infra containers are known before the scan
+	if dd.podDomain != "" && ep.name == "" {
+		if err := dd.checkPods(); err != nil {
+			log.Printf("[docker] ERROR: Failed to list pods: %s", err)
+		}
+	}
+
+	containers, err := ep.client.ListContainers(dockerapi.ListContainersOptions{})
 	if err != nil {
 		log.Printf("[docker] ERROR: Failed to list containers: %s", err)
 		return err
 	}
 	log.Printf("[docker] Found %d running containers at startup", len(containers))
 
+	// Queued like events, so that the scan never overwrites the work of
+	// events handled meanwhile with what it saw before
+	running := make(map[string]bool)
 	for _, apiContainer := range containers {
-		log.Printf("[docker] Inspecting container %s (names: %v)", apiContainer.ID[:12], apiContainer.Names)
-		container, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: apiContainer.ID})
-		if err != nil {
-			log.Printf("[docker] ERROR: Failed to inspect container %s: %s", apiContainer.ID[:12], err)
-			continue
+		running[apiContainer.ID] = true
+	}
+	tasks := dd.removeStaleEntries(ep, running)
+	for _, apiContainer := range containers {
+		log.Printf("[docker] Inspecting container %s (names: %v)", shortID(apiContainer.ID), apiContainer.Names)
+		tasks = append(tasks, dd.queueSync(ep, apiContainer.ID, "startup scan"))
+	}
+	if !waitTasks(tasks, stop) {
+		return nil
+	}
+
+	if dd.swarmDomain != "" && ep.name == "" {
+		if err := dd.syncSwarm(); err != nil {
+			log.Printf("[docker] ERROR: Failed to list swarm services: %s", err)
 		}
+	}
+
+	log.Printf("[docker] Startup container scan of %s complete. Listening for events...", ep.describe())
+	dd.setConnected(ep, true)
+	defer dd.setConnected(ep, false)
+
+	var resyncs <-chan time.Time
+	if dd.resyncInterval > 0 {
+		ticker := time.NewTicker(dd.resyncInterval)
+		defer ticker.Stop()
+		resyncs = ticker.C
+	}
 
-		// Debug: show labels
-		if container.Config != nil {
-			for label, value := range container.Config.Labels {
-				if strings.HasPrefix(label, "traefik.") || strings.HasPrefix(label, "coredns.") {
-					log.Printf("[docker]   Label: %s = %s", label, value)
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-resyncs:
+			go func() {
+				if _, err := dd.resync(ep); err != nil {
+					log.Printf("[docker] ERROR: Resync of %s failed: %s", ep.describe(), err)
 				}
+			}()
+		case msg, ok := <-events:
+			if !ok {
+				return errors.New("docker event stream closed")
 			}
+			if msg.Time > ep.lastEvent {
+				ep.lastEvent = msg.Time
+			}
+			dd.handleEvent(ep, msg)
 		}
+	}
+}
 
-		if err := dd.updateContainerInfo(container); err != nil {
-			log.Printf("[docker] Error adding A/AAAA records for container %s: %s\n", container.ID[:12], err)
+// networkKeyPrefix prefixes the queue keys of work on networks.
+const networkKeyPrefix = "network:"
+
+// handleEvent queues the work an event calls for. Work on a container is
+// serialized in the order of its events, and coalesced into the work of the
+// last one when they come in bursts: it brings the container's entry to the
+// container's current state, whichever event called for it.
+func (dd *DockerDiscovery) handleEvent(ep *dockerEndpoint, msg *dockerapi.APIEvents) {
+	event := fmt.Sprintf("%s:%s", msg.Type, msg.Action)
+	if msg.Action == "health_status" || strings.HasPrefix(msg.Action, "health_status:") {
+		return
+	}
+	log.Printf("[docker] Received event: %s (actor: %s)", event, shortID(msg.Actor.ID))
+	if dd.swarmDomain != "" && ep.name == "" && isSwarmEvent(msg) {
+		dd.queue.add(swarmKeyPrefix, func() {
+			if err := dd.syncSwarm(); err != nil {
+				log.Printf("[docker] Error syncing swarm services after %s: %s", event, err)
+			}
+		})
+	}
+	if dd.podDomain != "" && ep.name == "" && dd.isPodEvent(msg) {
+		dd.queue.add(podKeyPrefix, func() {
+			if err := dd.syncPods(); err != nil {
+				log.Printf("[docker] Error syncing pods after %s: %s", event, err)
+			}
+		})
+	}
+
+	var containerID string
+	switch event {
+	case "container:start":
+		log.Println("[docker] New container spawned. Attempt to add A/AAAA records for it")
+		containerID = msg.Actor.ID
+	case "container:rename":
+		// Names resolved from the container name change with it
+		log.Printf("[docker] Container %s renamed from %s to %s", shortID(msg.Actor.ID), msg.Actor.Attributes["oldName"], msg.Actor.Attributes["name"])
+		containerID = msg.Actor.ID
+	case "container:die":
+		log.Println("[docker] Container being stopped. Attempt to remove its A/AAAA records from the DNS", shortID(msg.Actor.ID))
+		dd.queueRemoval(ep, msg.Actor.ID)
+		return
+	case "container:destroy":
+		log.Printf("[docker] Container %s removed", shortID(msg.Actor.ID))
+		dd.queueRemoval(ep, msg.Actor.ID)
+		return
+	case "network:create":
+		// Containers naming the network were left unpublished until now:
+		// they aren't in containerInfoMap
+		name := msg.Actor.Attributes["name"]
+		if name != "" {
+			dd.queue.add(ep.key(networkKeyPrefix+name), func() {
+				dd.syncNetworkContainers(ep, name, event)
+			})
+		}
+		return
+	case "network:destroy":
+		// Containers only resolve through networks that exist
+		name := msg.Actor.Attributes["name"]
+		for _, id := range dd.networkContainers(ep, msg.Actor.ID, name) {
+			log.Printf("[docker] Network %s of container %s: %s. Resolving it again", name, shortID(id), msg.Action)
+			dd.queueSync(ep, id, event)
 		}
+		return
+	case "network:connect":
+		// take a look https://gist.github.com/josefkarasek/be9bac36921f7bc9a61df23451594fbf for example of same event's types attributes
+		log.Printf("[docker] Container %s being connected to network %s.", shortID(msg.Actor.Attributes["container"]), msg.Actor.Attributes["name"])
+		containerID = msg.Actor.Attributes["container"]
+	case "network:disconnect":
+		log.Printf("[docker] Container %s being disconnected from network %s", shortID(msg.Actor.Attributes["container"]), msg.Actor.Attributes["name"])
+		containerID = msg.Actor.Attributes["container"]
+	default:
+		return
 	}
+	dd.queueSync(ep, containerID, event)
+}
 
-	log.Println("[docker] Startup container scan complete. Listening for events...")
+// queueSync queues the sync of a container of the endpoint, with the work
+// of its events. It returns a channel closed once the sync is done.
+func (dd *DockerDiscovery) queueSync(ep *dockerEndpoint, containerID string, event string) <-chan struct{} {
+	return dd.queue.add(ep.key(containerID), func() {
+		dd.syncContainer(ep, containerID, event)
+	})
+}
 
-	for msg := range events {
-		go func(msg *dockerapi.APIEvents) {
-			event := fmt.Sprintf("%s:%s", msg.Type, msg.Action)
-			if msg.Action == "health_status" || strings.HasPrefix(msg.Action, "health_status:") {
-				return
-			}
-			log.Printf("[docker] Received event: %s (actor: %s)", event, shortID(msg.Actor.ID))
-			switch event {
-			case "container:start":
-				log.Println("[docker] New container spawned. Attempt to add A/AAAA records for it")
-
-				container, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: msg.Actor.ID})
-				if err != nil {
-					log.Printf("[docker] Event error %s #%s: %s", event, shortID(msg.Actor.ID), err)
-					return
-				}
-				if err := dd.updateContainerInfo(container); err != nil {
-					log.Printf("[docker] Error adding A/AAAA records for container %s: %s", shortID(container.ID), err)
-				}
-			case "container:die":
-				log.Println("[docker] Container being stopped. Attempt to remove its A/AAAA records from the DNS", shortID(msg.Actor.ID))
-				if err := dd.removeContainerInfo(msg.Actor.ID); err != nil {
-					log.Printf("[docker] Error deleting A/AAAA records for container: %s: %s", shortID(msg.Actor.ID), err)
-				}
-			case "network:connect":
-				// take a look https://gist.github.com/josefkarasek/be9bac36921f7bc9a61df23451594fbf for example of same event's types attributes
-				log.Printf("[docker] Container %s being connected to network %s.", shortID(msg.Actor.Attributes["container"]), msg.Actor.Attributes["name"])
-
-				container, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: msg.Actor.Attributes["container"]})
-				if err != nil {
-					log.Printf("[docker] Event error %s #%s: %s", event, shortID(msg.Actor.Attributes["container"]), err)
-					return
-				}
-				if err := dd.updateContainerInfo(container); err != nil {
-					log.Printf("[docker] Error adding A/AAAA records for container %s: %s", shortID(container.ID), err)
-				}
-			case "network:disconnect":
-				log.Printf("[docker] Container %s being disconnected from network %s", shortID(msg.Actor.Attributes["container"]), msg.Actor.Attributes["name"])
+// queueRemoval queues the removal of the entry of a container of the
+// endpoint.
+func (dd *DockerDiscovery) queueRemoval(ep *dockerEndpoint, containerID string) {
+	dd.queue.add(ep.key(containerID), func() {
+		if err := dd.removeContainerInfo(ep.key(containerID)); err != nil {
+			log.Printf("[docker] Error deleting A/AAAA records for container: %s: %s", shortID(containerID), err)
+		}
+	})
+}
 
-				container, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: msg.Actor.Attributes["container"]})
-				if err != nil {
-					log.Printf("[docker] Event error %s #%s: %s", event, shortID(msg.Actor.Attributes["container"]), err)
-					return
-				}
-				if err := dd.updateContainerInfo(container); err != nil {
-					log.Printf("[docker] Error adding A/AAAA records for container %s: %s", shortID(container.ID), err)
+// networkContainers returns the IDs of the endpoint's published containers
+// that use a network: attached to it, in its network mode or naming it in
+// their network label.
+func (dd *DockerDiscovery) networkContainers(ep *dockerEndpoint, networkID string, name string) []string {
+	dd.mutex.RLock()
+	defer dd.mutex.RUnlock()
+
+	var ids []string
+	for key, containerInfo := range dd.containerInfoMap {
+		container := containerInfo.container
+		if container == nil || key != ep.key(container.ID) || strings.Contains(container.ID, ":") || containerInfo.source != ep.name {
+			continue
+		}
+		uses := false
+		if container.Config != nil && name != "" && container.Config.Labels[dd.label("network")] == name {
+			uses = true
+		}
+		if container.HostConfig != nil && name != "" && container.HostConfig.NetworkMode == name {
+			uses = true
+		}
+		if container.NetworkSettings != nil {
+			for netName, network := range container.NetworkSettings.Networks {
+				if name != "" && netName == name || networkID != "" && network.NetworkID == networkID {
+					uses = true
 				}
 			}
-		}(msg)
+		}
+		if uses {
+			ids = append(ids, container.ID)
+		}
+	}
+	return ids
+}
+
+// syncNetworkContainers queues the sync of the endpoint's running
+// containers that are attached to a network or name it in their network
+// label, published or not.
+func (dd *DockerDiscovery) syncNetworkContainers(ep *dockerEndpoint, name string, event string) {
+	containers, err := ep.client.ListContainers(dockerapi.ListContainersOptions{})
+	if err != nil {
+		log.Printf("[docker] Error listing containers of network %s after %s: %s", name, event, err)
+		return
+	}
+	for _, container := range containers {
+		_, attached := container.Networks.Networks[name]
+		if attached || container.Labels[dd.label("network")] == name {
+			log.Printf("[docker] Network %s of container %s: %s. Resolving it again", name, shortID(container.ID), event)
+			dd.queueSync(ep, container.ID, event)
+		}
 	}
+}
 
-	return errors.New("docker event loop closed")
+// syncContainer publishes a container of the endpoint as it currently is,
+// or removes its entry when it isn't running anymore.
+func (dd *DockerDiscovery) syncContainer(ep *dockerEndpoint, containerID string, event string) {
+	container, err := ep.client.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: containerID})
+	var noSuchContainer *dockerapi.NoSuchContainer
+	if errors.As(err, &noSuchContainer) || err == nil && !container.State.Running {
+		// Stopped since the event or scan
+		if err := dd.removeContainerInfo(ep.key(containerID)); err != nil {
+			log.Printf("[docker] Error deleting A/AAAA records for container: %s: %s", shortID(containerID), err)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("[docker] Error inspecting container %s after %s: %s", shortID(containerID), event, err)
+		return
+	}
+	if err := dd.updateEndpointContainerInfo(ep, container); err != nil {
+		log.Printf("[docker] Error adding A/AAAA records for container %s: %s", shortID(container.ID), err)
+	}
 }
 
 // shortID safely truncates an ID string to at most 12 characters.
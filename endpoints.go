@@ -0,0 +1,159 @@
+package dockerdiscovery
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+// dockerEndpoint is a Docker host whose containers are published. The
+// endpoint of the docker directive is the primary one, with an empty name;
+// the endpoint directive adds others, each watched independently.
+type dockerEndpoint struct {
+	name   string
+	url    string
+	client *dockerapi.Client
+
+	// Address of the host (HOST_ADDRESS), for containers that are only
+	// reachable through it: the target of their proxy hosts (Traefik,
+	// caddy, ...) without a more specific one and, when an IP, the address
+	// of their A records. Nil for the primary endpoint.
+	target *recordTarget
+
+	// TLS files of the endpoint (its tls CERT_DIR), instead of the ones of
+	// tls_ca, tls_cert and tls_key. Nil for the primary endpoint.
+	tls *dockerTLSFiles
+
+	// Time of the last event received, from which events are replayed
+	// after a reconnection. Only used by the endpoint's watcher.
+	lastEvent int64
+
+	// Held by the resync of the endpoint in progress.
+	resyncMutex sync.Mutex
+}
+
+// key returns the containerInfoMap key of one of the endpoint's containers.
+// Containers of the primary endpoint are keyed by their ID alone.
+func (ep *dockerEndpoint) key(containerID string) string {
+	if ep.name == "" {
+		return containerID
+	}
+	return ep.name + "/" + containerID
+}
+
+// describe names the endpoint in logs.
+func (ep *dockerEndpoint) describe() string {
+	if ep.name == "" {
+		return ep.url
+	}
+	return ep.name + " (" + ep.url + ")"
+}
+
+// primaryEndpoint returns the endpoint of the docker directive.
+func (dd *DockerDiscovery) primaryEndpoint() *dockerEndpoint {
+	return &dockerEndpoint{url: dd.dockerEndpoint, client: dd.dockerClient}
+}
+
+// sourceRank orders entries of different endpoints claiming the same name:
+// the primary endpoint first, then the others in configuration order.
+func (dd *DockerDiscovery) sourceRank(source string) int {
+	if source == "" {
+		return 0
+	}
+	for i, ep := range dd.endpoints {
+		if ep.name == source {
+			return i + 1
+		}
+	}
+	return len(dd.endpoints) + 1
+}
+
+// warnConflicts logs the CNAME domains of a new entry that an entry of
+// another endpoint already claims. Lookups answer with the entry of the
+// endpoint ranked first by sourceRank. Called with the mutex held.
+func (dd *DockerDiscovery) warnConflicts(source string, cnameDomains []string) {
+	for _, containerInfo := range dd.containerInfoMap {
+		if containerInfo.source == source {
+			continue
+		}
+		for _, d := range cnameDomains {
+			if containsString(containerInfo.cnameDomains, d) {
+				log.Printf("[docker] WARNING: %s is claimed by containers on endpoints %q and %q", d, containerInfo.source, source)
+			}
+		}
+	}
+}
+
+// Delays between reconnection attempts to an endpoint, doubled after each
+// failure.
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = time.Minute
+)
+
+// supervise watches the endpoint until stop is closed, reconnecting with
+// exponential backoff whenever it can't be reached or its event stream
+// ends.
+func (dd *DockerDiscovery) supervise(ep *dockerEndpoint, stop <-chan struct{}) {
+	retryWithBackoff(stop, func() error {
+		return dd.watch(ep, stop)
+	}, func(err error, delay time.Duration) {
+		log.Printf("[docker] ERROR: Lost Docker endpoint %s: %s. Reconnecting in %s", ep.describe(), err, delay)
+	})
+}
+
+// retryWithBackoff runs run until stop is closed, running it again whenever
+// it returns, after a delay doubled after each failure, and reset after a
+// run that lasted. onError is called with the error of each failed run.
+func retryWithBackoff(stop <-chan struct{}, run func() error, onError func(err error, delay time.Duration)) {
+	delay := reconnectMinDelay
+	for {
+		started := time.Now()
+		err := run()
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if time.Since(started) > reconnectMaxDelay {
+			delay = reconnectMinDelay
+		}
+		onError(err, delay)
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// removeStaleEntries queues the sync of the endpoint's containers that
+// have an entry but weren't running, which drops their entries, with their
+// Cloudflare records and tunnel routes, unless they started since. It
+// returns the queued tasks.
+func (dd *DockerDiscovery) removeStaleEntries(ep *dockerEndpoint, running map[string]bool) []<-chan struct{} {
+	var stale []string
+	dd.mutex.RLock()
+	for key, containerInfo := range dd.containerInfoMap {
+		// Entries of Swarm services, pods, Traefik routers... have keys
+		// like prefix:name, which container IDs never look like
+		id := containerInfo.container.ID
+		if key == ep.key(id) && !strings.Contains(id, ":") && containerInfo.source == ep.name && !running[id] {
+			stale = append(stale, id)
+		}
+	}
+	dd.mutex.RUnlock()
+
+	var tasks []<-chan struct{}
+	for _, id := range stale {
+		log.Printf("[docker] Container %s of %s is not running anymore", shortID(id), ep.describe())
+		tasks = append(tasks, dd.queueSync(ep, id, "scan"))
+	}
+	return tasks
+}
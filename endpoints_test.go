@@ -0,0 +1,492 @@
+package dockerdiscovery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/docker/docker/api/types/swarm"
+	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDockerAPI is a stand-in for a Docker daemon whose event stream can
+// be fed, and dropped, as when the daemon restarts. It also serves the
+// services and tasks of a Swarm manager, and, once pods are set, the pods
+// of Podman's libpod API.
+type fakeDockerAPI struct {
+	mutex      sync.Mutex
+	containers map[string]*dockerapi.Container
+	services   []swarm.Service
+	tasks      []swarm.Task
+	pods       []podmanPod
+	events     chan dockerapi.APIEvents
+	drop       chan struct{}
+	since      []string // since parameter of every events request
+
+	// Delay of inspect responses, which describe the container as it was
+	// when requested
+	inspectDelay time.Duration
+}
+
+func newFakeDockerAPI() *fakeDockerAPI {
+	return &fakeDockerAPI{events: make(chan dockerapi.APIEvents, 16), drop: make(chan struct{})}
+}
+
+func (f *fakeDockerAPI) set(containers ...*dockerapi.Container) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.containers = make(map[string]*dockerapi.Container)
+	for _, container := range containers {
+		f.containers[container.ID] = container
+	}
+}
+
+func (f *fakeDockerAPI) setServices(services []swarm.Service, tasks []swarm.Task) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.services, f.tasks = services, tasks
+}
+
+func (f *fakeDockerAPI) setPods(pods []podmanPod) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.pods = pods
+}
+
+// emit sends an event on the current event stream. Events without a time,
+// which the client ignores, are sent as of now.
+func (f *fakeDockerAPI) emit(event dockerapi.APIEvents) {
+	if event.Time == 0 {
+		event.Time = time.Now().Unix()
+	}
+	f.events <- event
+}
+
+// restart ends the current event stream.
+func (f *fakeDockerAPI) restart() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	close(f.drop)
+	f.drop = make(chan struct{})
+}
+
+func (f *fakeDockerAPI) eventRequests() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.since...)
+}
+
+func (f *fakeDockerAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	switch {
+	case r.URL.Path == "/_ping":
+		f.mutex.Unlock()
+		w.Write([]byte("OK"))
+	case r.URL.Path == "/events":
+		f.since = append(f.since, r.URL.Query().Get("since"))
+		drop := f.drop
+		f.mutex.Unlock()
+		json.NewEncoder(w).Encode(dockerapi.APIEvents{Type: "network", Action: "create", Time: 1700000000})
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-f.events:
+				json.NewEncoder(w).Encode(event)
+				w.(http.Flusher).Flush()
+			case <-drop:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	case r.URL.Path == "/services":
+		services := f.services
+		f.mutex.Unlock()
+		json.NewEncoder(w).Encode(services)
+	case r.URL.Path == "/tasks":
+		tasks := f.tasks
+		f.mutex.Unlock()
+		json.NewEncoder(w).Encode(tasks)
+	case r.URL.Path == podmanPodsPath:
+		pods := f.pods
+		f.mutex.Unlock()
+		if pods == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(pods)
+	case r.URL.Path == "/containers/json":
+		var containers []dockerapi.APIContainers
+		for id, container := range f.containers {
+			apiContainer := dockerapi.APIContainers{ID: id, Names: []string{container.Name}}
+			if container.Config != nil {
+				apiContainer.Labels = container.Config.Labels
+			}
+			if container.NetworkSettings != nil {
+				apiContainer.Networks.Networks = container.NetworkSettings.Networks
+			}
+			containers = append(containers, apiContainer)
+		}
+		f.mutex.Unlock()
+		json.NewEncoder(w).Encode(containers)
+	default:
+		container := f.containers[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/containers/"), "/json")]
+		delay := f.inspectDelay
+		f.mutex.Unlock()
+		time.Sleep(delay)
+		if container == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(container)
+	}
+}
+
+func genRunningContainer(id string, name string, ip string) *dockerapi.Container {
+	return &dockerapi.Container{
+		ID:         id,
+		Name:       "/" + name,
+		Config:     &dockerapi.Config{Labels: map[string]string{}},
+		State:      dockerapi.State{Running: true},
+		HostConfig: &dockerapi.HostConfig{NetworkMode: "bridge"},
+		NetworkSettings: &dockerapi.NetworkSettings{
+			Networks: map[string]dockerapi.ContainerNetwork{"bridge": {IPAddress: ip}},
+		},
+	}
+}
+
+func TestEndpointConfig(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	endpoint node2 tcp://127.0.0.1:1 192.168.1.12
+	endpoint node3 tcp://127.0.0.1:1 node3.lan
+	endpoint node4 tcp://127.0.0.1:1
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	if assert.Len(t, dd.endpoints, 3) {
+		assert.Equal(t, "node2", dd.endpoints[0].name)
+		assert.Equal(t, "192.168.1.12", dd.endpoints[0].target.a.String())
+		assert.Equal(t, "node3.lan", dd.endpoints[1].target.cname)
+		assert.Nil(t, dd.endpoints[2].target)
+		assert.NotNil(t, dd.endpoints[2].client)
+	}
+
+	for _, config := range []string{
+		"endpoint node2",
+		"endpoint node2 tcp://127.0.0.1:1 192.168.1.12 extra",
+		"endpoint node/2 tcp://127.0.0.1:1",
+		"endpoint node2 tcp://127.0.0.1:1\n\tendpoint node2 tcp://127.0.0.1:2",
+	} {
+		c = caddy.NewTestController("dns", "docker {\n\t"+config+"\n}")
+		_, err = createPlugin(c)
+		assert.NotNil(t, err, config)
+	}
+}
+
+func TestEndpointContainers(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	domain docker.loc
+	traefik_cname traefik.lan
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	server := httptest.NewServer(newFakeDockerAPI())
+	t.Cleanup(server.Close)
+	client, err := dockerapi.NewClient(server.URL)
+	assert.Nil(t, err)
+	node2 := &dockerEndpoint{name: "node2", url: server.URL, client: client, target: &recordTarget{a: []byte{192, 168, 1, 12}}}
+	node3 := &dockerEndpoint{name: "node3", target: &recordTarget{cname: "node3.lan"}}
+	dd.endpoints = []*dockerEndpoint{node2, node3}
+
+	labels := map[string]string{"traefik.http.routers.app.rule": "Host(`app.example.com`)"}
+	assert.Nil(t, dd.updateEndpointContainerInfo(node3, genFilterContainer("nginx", labels, "proxy")))
+	assert.Nil(t, dd.updateEndpointContainerInfo(node2, genFilterContainer("nginx", labels, "proxy")))
+
+	// Same container ID on two hosts: two entries
+	assert.Len(t, dd.containerInfoMap, 2)
+	assert.Contains(t, dd.containerInfoMap, "node2/fa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7")
+
+	// A records use the host address when it's an IP
+	var addresses []string
+	for _, ip := range dd.addressesByDomain("app.docker.loc.", false) {
+		addresses = append(addresses, ip.String())
+	}
+	assert.ElementsMatch(t, []string{"192.168.1.12", "172.18.0.2"}, addresses)
+
+	// Conflicting proxy hosts resolve as on the first endpoint
+	result, _ := dd.containerInfoByDomain("app.example.com.")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "node2", result.containerInfo.source)
+		assert.Equal(t, "192.168.1.12", result.target.a.String())
+	}
+
+	// The primary endpoint comes first, with the default target
+	assert.Nil(t, dd.updateContainerInfo(genFilterContainer("nginx", labels, "proxy")))
+	result, _ = dd.containerInfoByDomain("app.example.com.")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "", result.containerInfo.source)
+		assert.Equal(t, "traefik.lan", result.target.cname)
+	}
+
+	// Removals are per host
+	assert.True(t, waitTasks(dd.removeStaleEntries(node2, map[string]bool{}), nil))
+	assert.Len(t, dd.containerInfoMap, 2)
+	assert.Nil(t, dd.removeContainerInfo(node3.key("fa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7")))
+	assert.Len(t, dd.containerInfoMap, 1)
+	result, _ = dd.containerInfoByDomain("app.example.com.")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "", result.containerInfo.source)
+	}
+}
+
+func TestSuperviseResync(t *testing.T) {
+	fake := newFakeDockerAPI()
+	server := httptest.NewServer(fake)
+	t.Cleanup(func() {
+		server.CloseClientConnections()
+		server.Close()
+	})
+
+	c := caddy.NewTestController("dns", `docker {
+	domain docker.loc
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	client, err := dockerapi.NewClient(server.URL)
+	assert.Nil(t, err)
+	ep := &dockerEndpoint{url: server.URL, client: client}
+
+	fake.set(
+		genRunningContainer("aa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "web", "172.17.0.2"),
+		genRunningContainer("bb155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "db", "172.17.0.3"),
+	)
+	// A synthetic entry, left alone by resyncs
+	dd.syncExternalEntries(traefikFileKeyPrefix, map[string]*ContainerInfo{
+		traefikFileKeyPrefix + "/etc/traefik/dynamic.yml": {cnameDomains: []string{"router.home.arpa"}},
+	})
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		dd.supervise(ep, stop)
+		close(done)
+	}()
+	lookup := func(name string) bool { return len(dd.addressesByDomain(name, false)) > 0 }
+	assert.Eventually(t, func() bool { return lookup("web.docker.loc.") && lookup("db.docker.loc.") }, 2*time.Second, 10*time.Millisecond)
+
+	// The daemon restarts: web stopped and cache started meanwhile
+	fake.set(
+		genRunningContainer("bb155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "db", "172.17.0.3"),
+		genRunningContainer("cc155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "cache", "172.17.0.4"),
+	)
+	fake.restart()
+	assert.Eventually(t, func() bool { return lookup("cache.docker.loc.") && !lookup("web.docker.loc.") }, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, lookup("db.docker.loc."))
+	result, _ := dd.containerInfoByDomain("router.home.arpa.")
+	assert.NotNil(t, result)
+
+	// Missed events are replayed from the last one received
+	requests := fake.eventRequests()
+	if assert.GreaterOrEqual(t, len(requests), 2) {
+		assert.Equal(t, "", requests[0])
+		assert.Equal(t, "1700000000", requests[len(requests)-1])
+	}
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("supervise didn't stop")
+	}
+}
+
+func TestContainerLifecycleEvents(t *testing.T) {
+	fake := newFakeDockerAPI()
+	server := httptest.NewServer(fake)
+	t.Cleanup(func() {
+		server.CloseClientConnections()
+		server.Close()
+	})
+
+	c := caddy.NewTestController("dns", `docker {
+	domain docker.loc
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	client, err := dockerapi.NewClient(server.URL)
+	assert.Nil(t, err)
+	ep := &dockerEndpoint{url: server.URL, client: client}
+
+	web := genRunningContainer("aa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "web", "")
+	web.HostConfig.NetworkMode = "front"
+	web.NetworkSettings.Networks = map[string]dockerapi.ContainerNetwork{"front": {NetworkID: "f1", IPAddress: "172.18.0.2"}}
+	db := genRunningContainer("bb155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "db", "172.17.0.3")
+	fake.set(web, db)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		dd.supervise(ep, stop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+	lookup := func(name string) bool { return len(dd.addressesByDomain(name, false)) > 0 }
+	assert.Eventually(t, func() bool { return lookup("web.docker.loc.") && lookup("db.docker.loc.") }, 2*time.Second, 10*time.Millisecond)
+
+	// docker rename web frontend
+	renamed := *web
+	renamed.Name = "/frontend"
+	fake.set(&renamed, db)
+	fake.emit(dockerapi.APIEvents{Type: "container", Action: "rename", Actor: dockerapi.APIActor{
+		ID:         web.ID,
+		Attributes: map[string]string{"name": "frontend", "oldName": "/web"},
+	}})
+	assert.Eventually(t, func() bool { return lookup("frontend.docker.loc.") && !lookup("web.docker.loc.") }, 2*time.Second, 10*time.Millisecond)
+
+	// The front network goes away, without disconnect events
+	detached := renamed
+	detached.NetworkSettings = &dockerapi.NetworkSettings{}
+	fake.set(&detached, db)
+	fake.emit(dockerapi.APIEvents{Type: "network", Action: "destroy", Actor: dockerapi.APIActor{
+		ID:         "f1",
+		Attributes: map[string]string{"name": "front", "type": "bridge"},
+	}})
+	assert.Eventually(t, func() bool { return !lookup("frontend.docker.loc.") }, 2*time.Second, 10*time.Millisecond)
+	assert.True(t, lookup("db.docker.loc."))
+
+	// docker rm -f db, whose die event was missed
+	fake.set(&detached)
+	fake.emit(dockerapi.APIEvents{Type: "container", Action: "destroy", Actor: dockerapi.APIActor{ID: db.ID}})
+	assert.Eventually(t, func() bool { return !lookup("db.docker.loc.") }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestNetworkContainers(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	domain docker.loc
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	primary := dd.primaryEndpoint()
+	node2 := &dockerEndpoint{name: "node2"}
+
+	attached := genRunningContainer("aa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "attached", "")
+	attached.NetworkSettings.Networks = map[string]dockerapi.ContainerNetwork{"back": {NetworkID: "b1", IPAddress: "172.19.0.2"}}
+	labeled := genRunningContainer("bb155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "labeled", "172.17.0.3")
+	labeled.Config.Labels[dd.label("network")] = "back"
+	labeled.NetworkSettings.Networks["back"] = dockerapi.ContainerNetwork{IPAddress: "172.19.0.3"}
+	other := genRunningContainer("cc155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "other", "172.17.0.4")
+	for _, container := range []*dockerapi.Container{attached, labeled, other} {
+		assert.Nil(t, dd.updateContainerInfo(container))
+	}
+	assert.Nil(t, dd.updateEndpointContainerInfo(node2, attached))
+
+	assert.ElementsMatch(t, []string{attached.ID, labeled.ID}, dd.networkContainers(primary, "b1", "back"))
+	assert.ElementsMatch(t, []string{attached.ID}, dd.networkContainers(primary, "b1", ""))
+	assert.ElementsMatch(t, []string{attached.ID}, dd.networkContainers(node2, "b1", "back"))
+	assert.Empty(t, dd.networkContainers(primary, "f1", "front"))
+}
+
+func TestResyncAndDieEvent(t *testing.T) {
+	fake := newFakeDockerAPI()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	c := caddy.NewTestController("dns", `docker {
+	domain docker.loc
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	client, err := dockerapi.NewClient(server.URL)
+	assert.Nil(t, err)
+	ep := &dockerEndpoint{url: server.URL, client: client}
+
+	// The resync inspects web, which dies before the resync publishes it
+	web := genRunningContainer("aa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "web", "172.17.0.2")
+	fake.set(web)
+	fake.mutex.Lock()
+	fake.inspectDelay = 50 * time.Millisecond
+	fake.mutex.Unlock()
+	resynced := make(chan struct{})
+	go func() {
+		dd.resync(ep)
+		close(resynced)
+	}()
+	time.Sleep(30 * time.Millisecond)
+	fake.set()
+	dd.handleEvent(ep, &dockerapi.APIEvents{Type: "container", Action: "die", Actor: dockerapi.APIActor{ID: web.ID}})
+	<-resynced
+	dd.queue.shutdown()
+	assert.Empty(t, dd.addressesByDomain("web.docker.loc.", false))
+}
+
+func TestNetworkRecreated(t *testing.T) {
+	fake := newFakeDockerAPI()
+	server := httptest.NewServer(fake)
+	t.Cleanup(func() {
+		server.CloseClientConnections()
+		server.Close()
+	})
+
+	c := caddy.NewTestController("dns", `docker {
+	domain docker.loc
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	client, err := dockerapi.NewClient(server.URL)
+	assert.Nil(t, err)
+	ep := &dockerEndpoint{url: server.URL, client: client}
+
+	// api uses the back network, which worker names but isn't attached to
+	api := genRunningContainer("aa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "api", "")
+	api.Config.Labels[dd.label("network")] = "back"
+	api.NetworkSettings.Networks = map[string]dockerapi.ContainerNetwork{"back": {NetworkID: "b1", IPAddress: "172.19.0.2"}}
+	worker := genRunningContainer("bb155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "worker", "172.17.0.3")
+	worker.Config.Labels[dd.label("network")] = "back"
+	fake.set(api, worker)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		dd.supervise(ep, stop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+	lookup := func(name string) bool { return len(dd.addressesByDomain(name, false)) > 0 }
+	assert.Eventually(t, func() bool { return lookup("api.docker.loc.") }, 2*time.Second, 10*time.Millisecond)
+	assert.False(t, lookup("worker.docker.loc."))
+
+	// docker network rm back: api isn't published anymore
+	detached := *api
+	detached.NetworkSettings = &dockerapi.NetworkSettings{}
+	fake.set(&detached, worker)
+	fake.emit(dockerapi.APIEvents{Type: "network", Action: "destroy", Actor: dockerapi.APIActor{
+		ID:         "b1",
+		Attributes: map[string]string{"name": "back", "type": "bridge"},
+	}})
+	assert.Eventually(t, func() bool { return !lookup("api.docker.loc.") }, 2*time.Second, 10*time.Millisecond)
+
+	// docker network create back, both containers attached again: both
+	// are published, though neither has an entry
+	attached := *worker
+	attached.NetworkSettings = &dockerapi.NetworkSettings{
+		Networks: map[string]dockerapi.ContainerNetwork{"back": {NetworkID: "b2", IPAddress: "172.19.0.3"}},
+	}
+	fake.set(api, &attached)
+	fake.emit(dockerapi.APIEvents{Type: "network", Action: "create", Actor: dockerapi.APIActor{
+		ID:         "b2",
+		Attributes: map[string]string{"name": "back", "type": "bridge"},
+	}})
+	assert.Eventually(t, func() bool { return lookup("api.docker.loc.") && lookup("worker.docker.loc.") }, 2*time.Second, 10*time.Millisecond)
+}
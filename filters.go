@@ -0,0 +1,131 @@
+package dockerdiscovery
+
+import (
+	"regexp"
+	"strings"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+// ContainerFilter decides which containers get records at all. It is
+// evaluated before any resolver runs, modelled on Traefik's
+// exposedByDefault + traefik.enable and its container constraints.
+type ContainerFilter struct {
+	exposeByDefault bool             // publish containers without an enable label
+	enableLabel     string           // per-container opt-in/opt-out label
+	includeLabels   []labelMatcher   // at least one must match, if any
+	excludeImages   []*regexp.Regexp // none may match
+	includeNetworks map[string]bool  // container must be on one, if any
+	composeProjects map[string]bool  // compose project must be listed, if any
+}
+
+// NewContainerFilter returns a filter that allows every container.
+func NewContainerFilter() *ContainerFilter {
+	return &ContainerFilter{
+		exposeByDefault: true,
+		enableLabel:     defaultLabelPrefix + ".enable",
+		includeNetworks: make(map[string]bool),
+		composeProjects: make(map[string]bool),
+	}
+}
+
+// labelMatcher matches a label by key, and by value when one is given.
+type labelMatcher struct {
+	key      string
+	value    string
+	anyValue bool
+}
+
+// parseLabelMatcher parses KEY or KEY=VALUE.
+func parseLabelMatcher(s string) labelMatcher {
+	if key, value, ok := strings.Cut(s, "="); ok {
+		return labelMatcher{key: key, value: value}
+	}
+	return labelMatcher{key: s, anyValue: true}
+}
+
+func (m labelMatcher) matches(labels map[string]string) bool {
+	value, ok := labels[m.key]
+	return ok && (m.anyValue || value == m.value)
+}
+
+// compileImageGlob turns an image pattern where '*' matches any run of
+// characters (including '/') and '?' a single character into a regexp.
+func compileImageGlob(glob string) (*regexp.Regexp, error) {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+	pattern = strings.ReplaceAll(pattern, `\?`, ".")
+	return regexp.Compile("^" + pattern + "$")
+}
+
+// imageRepository strips the tag and digest from an image reference:
+// "ghcr.io/org/app:1.2@sha256:..." becomes "ghcr.io/org/app".
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// allows reports whether the container should get any records.
+func (f *ContainerFilter) allows(container *dockerapi.Container) bool {
+	var labels map[string]string
+	var image string
+	if container.Config != nil {
+		labels = container.Config.Labels
+		image = container.Config.Image
+	}
+
+	switch strings.ToLower(labels[f.enableLabel]) {
+	case "true":
+	case "false":
+		return false
+	default:
+		if !f.exposeByDefault {
+			return false
+		}
+	}
+
+	if len(f.includeLabels) > 0 {
+		matched := false
+		for _, m := range f.includeLabels {
+			if m.matches(labels) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for _, re := range f.excludeImages {
+		if re.MatchString(image) || re.MatchString(imageRepository(image)) {
+			return false
+		}
+	}
+
+	if len(f.includeNetworks) > 0 {
+		matched := false
+		if container.NetworkSettings != nil {
+			for name := range container.NetworkSettings.Networks {
+				if f.includeNetworks[name] {
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(f.composeProjects) > 0 && !f.composeProjects[labels["com.docker.compose.project"]] {
+		return false
+	}
+
+	return true
+}
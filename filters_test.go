@@ -0,0 +1,119 @@
+package dockerdiscovery
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func genFilterContainer(image string, labels map[string]string, networks ...string) *dockerapi.Container {
+	container := &dockerapi.Container{
+		ID:   "fa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7",
+		Name: "/app",
+		Config: &dockerapi.Config{
+			Image:  image,
+			Labels: labels,
+		},
+		HostConfig: &dockerapi.HostConfig{},
+		NetworkSettings: &dockerapi.NetworkSettings{
+			Networks: map[string]dockerapi.ContainerNetwork{},
+		},
+	}
+	for _, network := range networks {
+		container.NetworkSettings.Networks[network] = dockerapi.ContainerNetwork{IPAddress: "172.18.0.2"}
+	}
+	return container
+}
+
+func TestContainerFilterEnableLabel(t *testing.T) {
+	filter := NewContainerFilter()
+	assert.True(t, filter.allows(genFilterContainer("nginx", map[string]string{})))
+	assert.True(t, filter.allows(genFilterContainer("nginx", map[string]string{"coredns.dockerdiscovery.enable": "true"})))
+	assert.False(t, filter.allows(genFilterContainer("nginx", map[string]string{"coredns.dockerdiscovery.enable": "false"})))
+
+	filter.exposeByDefault = false
+	assert.False(t, filter.allows(genFilterContainer("nginx", map[string]string{})))
+	assert.True(t, filter.allows(genFilterContainer("nginx", map[string]string{"coredns.dockerdiscovery.enable": "True"})))
+	assert.False(t, filter.allows(genFilterContainer("nginx", map[string]string{"coredns.dockerdiscovery.enable": "false"})))
+}
+
+func TestContainerFilterIncludeLabel(t *testing.T) {
+	filter := NewContainerFilter()
+	filter.includeLabels = []labelMatcher{parseLabelMatcher("dns"), parseLabelMatcher("tier=front")}
+
+	assert.True(t, filter.allows(genFilterContainer("nginx", map[string]string{"dns": ""})))
+	assert.True(t, filter.allows(genFilterContainer("nginx", map[string]string{"tier": "front"})))
+	assert.False(t, filter.allows(genFilterContainer("nginx", map[string]string{"tier": "back"})))
+	assert.False(t, filter.allows(genFilterContainer("nginx", map[string]string{})))
+}
+
+func TestContainerFilterExcludeImage(t *testing.T) {
+	filter := NewContainerFilter()
+	for _, glob := range []string{"moby/buildkit", "*/pause", "busybox:?"} {
+		re, err := compileImageGlob(glob)
+		assert.Nil(t, err)
+		filter.excludeImages = append(filter.excludeImages, re)
+	}
+
+	assert.False(t, filter.allows(genFilterContainer("moby/buildkit:buildx-stable-1", nil)))
+	assert.False(t, filter.allows(genFilterContainer("registry.k8s.io/pause:3.9", nil)))
+	assert.False(t, filter.allows(genFilterContainer("busybox:1", nil)))
+	assert.True(t, filter.allows(genFilterContainer("busybox:1.36", nil)))
+	assert.True(t, filter.allows(genFilterContainer("nginx:1.25", nil)))
+}
+
+func TestContainerFilterNetworkAndCompose(t *testing.T) {
+	filter := NewContainerFilter()
+	filter.includeNetworks["proxy"] = true
+	assert.True(t, filter.allows(genFilterContainer("nginx", nil, "proxy", "default")))
+	assert.False(t, filter.allows(genFilterContainer("nginx", nil, "default")))
+
+	filter = NewContainerFilter()
+	filter.composeProjects["media"] = true
+	assert.True(t, filter.allows(genFilterContainer("nginx", map[string]string{"com.docker.compose.project": "media"})))
+	assert.False(t, filter.allows(genFilterContainer("nginx", map[string]string{"com.docker.compose.project": "ci"})))
+	assert.False(t, filter.allows(genFilterContainer("nginx", nil)))
+}
+
+func TestImageRepository(t *testing.T) {
+	assert.Equal(t, "nginx", imageRepository("nginx:1.25"))
+	assert.Equal(t, "nginx", imageRepository("nginx"))
+	assert.Equal(t, "localhost:5000/app", imageRepository("localhost:5000/app"))
+	assert.Equal(t, "localhost:5000/app", imageRepository("localhost:5000/app:dev"))
+	assert.Equal(t, "ghcr.io/org/app", imageRepository("ghcr.io/org/app:1.2@sha256:abcd"))
+}
+
+func TestContainerFilterConfig(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	domain docker.loc
+	expose_by_default false
+	include_label dns tier=front
+	exclude_image moby/buildkit
+	include_network proxy
+	include_compose_project media homelab
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.False(t, dd.filter.exposeByDefault)
+	assert.Equal(t, 2, len(dd.filter.includeLabels))
+	assert.Equal(t, 1, len(dd.filter.excludeImages))
+	assert.True(t, dd.filter.includeNetworks["proxy"])
+	assert.True(t, dd.filter.composeProjects["homelab"])
+
+	// Filtered containers get no records
+	container := genFilterContainer("nginx", map[string]string{"dns": "", "com.docker.compose.project": "media"}, "proxy")
+	domains, _, _, _ := dd.resolveDomainsByContainer(container)
+	assert.Empty(t, domains)
+
+	container.Config.Labels["coredns.dockerdiscovery.enable"] = "true"
+	domains, _, _, _ = dd.resolveDomainsByContainer(container)
+	assert.Equal(t, []string{"app.docker.loc"}, domains)
+
+	c = caddy.NewTestController("dns", `docker {
+	expose_by_default maybe
+}`)
+	_, err = createPlugin(c)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,20 @@
+package dockerdiscovery
+
+import (
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// resyncFixes counts the differences between the records and the Docker
+	// state fixed by resyncs, by endpoint (empty for the primary one) and
+	// kind (added, removed, updated).
+	resyncFixes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "docker",
+		Name:      "resync_fixes_total",
+		Help:      "The count of record differences fixed by resyncs.",
+	}, []string{"endpoint", "kind"})
+)
@@ -0,0 +1,225 @@
+package dockerdiscovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+// podKeyPrefix prefixes the containerInfoMap keys of Podman pods.
+const podKeyPrefix = "pod:"
+
+// podmanPodsPath lists pods in Podman's own (libpod) API, served on the same
+// socket as its Docker-compatible API.
+const podmanPodsPath = "/v4.0.0/libpod/pods/json"
+
+// errNoLibpod is returned when listing pods from an endpoint without
+// Podman's libpod API, e.g. a Docker daemon.
+var errNoLibpod = errors.New("the endpoint doesn't serve Podman's libpod API (podman_pods requires a Podman 4+ socket), pods are not published")
+
+// podmanPod is a pod as listed by Podman.
+type podmanPod struct {
+	ID         string            `json:"Id"`
+	Name       string            `json:"Name"`
+	InfraID    string            `json:"InfraId"`
+	Labels     map[string]string `json:"Labels"`
+	Containers []struct {
+		ID    string `json:"Id"`
+		Names string `json:"Names"`
+	} `json:"Containers"`
+}
+
+// libpodURL returns the URL of a libpod API path on the client's endpoint.
+func libpodURL(client *dockerapi.Client, path string) string {
+	u, err := url.Parse(client.Endpoint())
+	if err != nil {
+		return client.Endpoint() + path
+	}
+	switch u.Scheme {
+	case "unix":
+		// Dialed through the socket by the client's transport, as the
+		// client itself does
+		return "http://unix.sock" + path
+	case "tcp":
+		u.Scheme = "http"
+		if client.TLSConfig != nil {
+			u.Scheme = "https"
+		}
+	}
+	return strings.TrimRight(u.String(), "/") + path
+}
+
+func (dd *DockerDiscovery) listPodmanPods() ([]podmanPod, error) {
+	resp, err := dd.dockerClient.HTTPClient.Get(libpodURL(dd.dockerClient, podmanPodsPath))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNoLibpod
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s (is the endpoint a Podman 4+ socket?)", podmanPodsPath, resp.Status)
+	}
+	var pods []podmanPod
+	if err := json.NewDecoder(resp.Body).Decode(&pods); err != nil {
+		return nil, err
+	}
+	return pods, nil
+}
+
+// syncPods publishes every pod with an infra container as
+// <pod>.<pod domain>, and its members as <member>.<pod>.<pod domain>, all
+// resolving to the infra container's IP, which the members share. Pod
+// labels are read like container labels (hosts, Traefik rules, ...).
+// Once the endpoint turned out not to serve the libpod API, pods aren't
+// listed anymore until checkPods.
+func (dd *DockerDiscovery) syncPods() error {
+	dd.podMutex.Lock()
+	defer dd.podMutex.Unlock()
+
+	if dd.noLibpod {
+		return nil
+	}
+	pods, err := dd.listPodmanPods()
+	if errors.Is(err, errNoLibpod) {
+		dd.noLibpod = true
+	}
+	if err != nil {
+		return err
+	}
+
+	infraIDs := make(map[string]bool)
+	for _, pod := range pods {
+		if pod.InfraID != "" {
+			infraIDs[pod.InfraID] = true
+		}
+	}
+	dd.podInfraMutex.Lock()
+	dd.podInfraIDs = infraIDs
+	dd.podInfraMutex.Unlock()
+
+	// Infra containers published before their pod was known, removed in
+	// order with their events
+	primary := dd.primaryEndpoint()
+	for id := range infraIDs {
+		dd.mutex.RLock()
+		_, ok := dd.containerInfoMap[primary.key(id)]
+		dd.mutex.RUnlock()
+		if ok {
+			dd.queueRemoval(primary, id)
+		}
+	}
+
+	entries := make(map[string]*ContainerInfo)
+	for _, pod := range pods {
+		podName := normalizeDNSLabel(pod.Name)
+		if pod.InfraID == "" || podName == "" {
+			// Without an infra container, members don't share a network
+			continue
+		}
+		infra, err := dd.dockerClient.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: pod.InfraID})
+		if err != nil {
+			log.Printf("[docker] Error inspecting infra container of pod %s: %s", pod.Name, err)
+			continue
+		}
+
+		// The pod as a container: its labels, the infra container's network
+		container := &dockerapi.Container{
+			ID:              pod.ID,
+			Name:            pod.Name,
+			Config:          &dockerapi.Config{Labels: pod.Labels},
+			HostConfig:      infra.HostConfig,
+			NetworkSettings: infra.NetworkSettings,
+		}
+		if container.Config.Labels == nil {
+			container.Config.Labels = make(map[string]string)
+		}
+		if container.HostConfig == nil {
+			container.HostConfig = &dockerapi.HostConfig{}
+		}
+		if container.NetworkSettings == nil {
+			container.NetworkSettings = &dockerapi.NetworkSettings{}
+		}
+		if !dd.filter.allows(container) {
+			log.Printf("[docker] Pod %s is excluded by filters", pod.Name)
+			continue
+		}
+
+		address, err := dd.getContainerAddress(container, false)
+		if err != nil {
+			log.Printf("[docker] Could not resolve IP for pod %s: %s", pod.Name, err)
+		}
+		var address6 net.IP
+		if address != nil {
+			address6, _ = dd.getContainerAddress(container, true)
+		}
+
+		domains, cnameDomains, cnameTargets, _ := dd.resolveDomainsByContainer(container)
+		names := []string{podName + "." + dd.podDomain}
+		for _, member := range pod.Containers {
+			if memberName := normalizeDNSLabel(member.Names); member.ID != pod.InfraID && memberName != "" {
+				names = append(names, memberName+"."+podName+"."+dd.podDomain)
+			}
+		}
+		for _, d := range rewriteNames(dd.nameRewrites, names) {
+			if !containsString(domains, d) {
+				domains = append(domains, d)
+			}
+		}
+		if address == nil {
+			domains = nil
+		}
+
+		entries[podKeyPrefix+pod.ID] = &ContainerInfo{
+			container:    container,
+			address:      address,
+			address6:     address6,
+			domains:      domains,
+			cnameDomains: cnameDomains,
+			cnameTargets: cnameTargets,
+		}
+	}
+
+	dd.syncExternalEntries(podKeyPrefix, entries)
+	return nil
+}
+
+// checkPods syncs the pods of a newly connected endpoint, which may serve
+// the libpod API even if the previous one didn't.
+func (dd *DockerDiscovery) checkPods() error {
+	dd.podMutex.Lock()
+	dd.noLibpod = false
+	dd.podMutex.Unlock()
+	return dd.syncPods()
+}
+
+// isPodInfra reports whether the container is the infra container of a pod.
+func (dd *DockerDiscovery) isPodInfra(id string) bool {
+	dd.podInfraMutex.RLock()
+	defer dd.podInfraMutex.RUnlock()
+	return dd.podInfraIDs[id]
+}
+
+// isPodEvent reports whether the event may change the pods or their
+// members: pod events, and the events of containers of pods, which Podman
+// tags with their pod (podId attribute), and of known infra containers.
+func (dd *DockerDiscovery) isPodEvent(msg *dockerapi.APIEvents) bool {
+	switch msg.Type {
+	case "pod":
+		return true
+	case "container":
+		switch msg.Action {
+		case "start", "die", "destroy", "rename":
+			return msg.Actor.Attributes["podId"] != "" || dd.isPodInfra(msg.Actor.ID)
+		}
+	}
+	return false
+}
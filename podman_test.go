@@ -0,0 +1,154 @@
+package dockerdiscovery
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func genPodmanPod(id string, name string, infraID string, labels map[string]string, members map[string]string) podmanPod {
+	pod := podmanPod{ID: id, Name: name, InfraID: infraID, Labels: labels}
+	for memberID, memberName := range members {
+		pod.Containers = append(pod.Containers, struct {
+			ID    string `json:"Id"`
+			Names string `json:"Names"`
+		}{memberID, memberName})
+	}
+	return pod
+}
+
+func genInfraContainer(id string, ip string) *dockerapi.Container {
+	return &dockerapi.Container{
+		ID:         id,
+		Name:       "/" + id[:12] + "-infra",
+		Config:     &dockerapi.Config{Labels: map[string]string{}},
+		HostConfig: &dockerapi.HostConfig{NetworkMode: "podman"},
+		NetworkSettings: &dockerapi.NetworkSettings{
+			Networks: map[string]dockerapi.ContainerNetwork{"podman": {IPAddress: ip}},
+		},
+	}
+}
+
+func TestSyncPods(t *testing.T) {
+	fake := newFakeDockerAPI()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	c := caddy.NewTestController("dns", `docker unix:///run/podman/podman.sock {
+	domain docker.loc
+	podman_pods pod.lan
+	traefik_cname traefik.lan
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "pod.lan", dd.podDomain)
+	dd.dockerClient, err = dockerapi.NewClient(server.URL)
+	assert.Nil(t, err)
+
+	infraID := "1f2e3d4c5b6a1f2e3d4c5b6a"
+	fake.set(genInfraContainer(infraID, "10.88.0.4"))
+	fake.setPods([]podmanPod{
+		genPodmanPod("pod-blog", "blog", infraID,
+			map[string]string{"traefik.http.routers.blog.rule": "Host(`blog.example.com`)"},
+			map[string]string{infraID: "1f2e3d4c5b6a-infra", "member-app": "blog_app", "member-db": "db"}),
+		genPodmanPod("pod-bare", "bare", "", nil, map[string]string{"member-bare": "bare"}),
+	})
+
+	// Published before the pod was known
+	assert.Nil(t, dd.updateContainerInfo(genInfraContainer(infraID, "10.88.0.4")))
+	assert.NotEmpty(t, dd.addressesByDomain("1f2e3d4c5b6a-infra.docker.loc.", false))
+
+	assert.Nil(t, dd.syncPods())
+	for _, name := range []string{"blog.pod.lan.", "blog-app.blog.pod.lan.", "db.blog.pod.lan."} {
+		addresses := dd.addressesByDomain(name, false)
+		if assert.Len(t, addresses, 1, name) {
+			assert.Equal(t, "10.88.0.4", addresses[0].String())
+		}
+	}
+	// Removed in order with the container's events
+	assert.Eventually(t, func() bool {
+		return len(dd.addressesByDomain("1f2e3d4c5b6a-infra.docker.loc.", false)) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Empty(t, dd.addressesByDomain("1f2e3d4c5b6a-infra.blog.pod.lan.", false))
+	assert.Empty(t, dd.addressesByDomain("bare.pod.lan.", false))
+
+	// Pod labels are read like container labels
+	result, _ := dd.containerInfoByDomain("blog.example.com.")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "traefik.lan", result.target.cname)
+	}
+
+	// Infra containers aren't published on their own anymore
+	domains, _, _, _ := dd.resolveDomainsByContainer(genInfraContainer(infraID, "10.88.0.4"))
+	assert.Empty(t, domains)
+
+	// Removed pods lose their records
+	fake.setPods([]podmanPod{})
+	fake.set()
+	assert.Nil(t, dd.syncPods())
+	assert.Empty(t, dd.addressesByDomain("blog.pod.lan.", false))
+	assert.Empty(t, dd.containerInfoMap)
+}
+
+func TestSyncPodsWithoutLibpod(t *testing.T) {
+	fake := newFakeDockerAPI()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	c := caddy.NewTestController("dns", `docker unix:///var/run/docker.sock {
+	podman_pods pod.lan
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	dd.dockerClient, err = dockerapi.NewClient(server.URL)
+	assert.Nil(t, err)
+
+	// A Docker daemon: reported once, then pods aren't listed anymore
+	assert.ErrorIs(t, dd.checkPods(), errNoLibpod)
+	fake.setPods([]podmanPod{genPodmanPod("pod-blog", "blog", "", nil, nil)})
+	assert.Nil(t, dd.syncPods())
+	assert.True(t, dd.noLibpod)
+
+	// until the endpoint reconnects
+	assert.Nil(t, dd.checkPods())
+	assert.False(t, dd.noLibpod)
+}
+
+func TestPodmanConfig(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	podman_pods
+}`)
+	_, err := createPlugin(c)
+	assert.NotNil(t, err)
+}
+
+func TestLibpodURL(t *testing.T) {
+	for endpoint, expected := range map[string]string{
+		"unix:///run/podman/podman.sock": "http://unix.sock" + podmanPodsPath,
+		"tcp://10.0.0.1:8080":            "http://10.0.0.1:8080" + podmanPodsPath,
+		"http://10.0.0.1:8080/":          "http://10.0.0.1:8080" + podmanPodsPath,
+	} {
+		client, err := dockerapi.NewClient(endpoint)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, libpodURL(client, podmanPodsPath), endpoint)
+	}
+}
+
+func TestIsPodEvent(t *testing.T) {
+	dd := NewDockerDiscovery(defaultDockerEndpoint)
+	dd.podInfraIDs = map[string]bool{"infra": true}
+	member := dockerapi.APIActor{ID: "member", Attributes: map[string]string{"podId": "pod-blog"}}
+
+	assert.True(t, dd.isPodEvent(&dockerapi.APIEvents{Type: "pod", Action: "start"}))
+	assert.True(t, dd.isPodEvent(&dockerapi.APIEvents{Type: "container", Action: "die", Actor: member}))
+	assert.True(t, dd.isPodEvent(&dockerapi.APIEvents{Type: "container", Action: "destroy", Actor: dockerapi.APIActor{ID: "infra"}}))
+	assert.False(t, dd.isPodEvent(&dockerapi.APIEvents{Type: "container", Action: "exec_start", Actor: member}))
+	// Containers outside of pods
+	assert.False(t, dd.isPodEvent(&dockerapi.APIEvents{Type: "container", Action: "start", Actor: dockerapi.APIActor{ID: "web"}}))
+	assert.False(t, dd.isPodEvent(&dockerapi.APIEvents{Type: "container", Action: "start", Actor: dockerapi.APIActor{ID: "web", Attributes: map[string]string{"podId": ""}}}))
+	assert.False(t, dd.isPodEvent(&dockerapi.APIEvents{Type: "network", Action: "connect"}))
+}
@@ -0,0 +1,70 @@
+package dockerdiscovery
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+)
+
+// Ready implements ready.Readiness: the plugin is ready once the running
+// containers of every endpoint are published and their events followed,
+// and not while an endpoint is disconnected.
+func (dd *DockerDiscovery) Ready() bool {
+	dd.connectedMutex.RLock()
+	defer dd.connectedMutex.RUnlock()
+	return dd.allConnected()
+}
+
+// allConnected reports whether every endpoint is connected. Called with
+// connectedMutex held.
+func (dd *DockerDiscovery) allConnected() bool {
+	if !dd.connected[""] {
+		return false
+	}
+	for _, ep := range dd.endpoints {
+		if !dd.connected[ep.name] {
+			return false
+		}
+	}
+	return true
+}
+
+// setConnected records whether the endpoint's containers are published and
+// its events followed.
+func (dd *DockerDiscovery) setConnected(ep *dockerEndpoint, connected bool) {
+	dd.connectedMutex.Lock()
+	defer dd.connectedMutex.Unlock()
+	dd.connected[ep.name] = connected
+	if dd.allConnected() {
+		dd.scannedOnce.Do(func() {
+			log.Println("[docker] Initial scan of every endpoint complete. Ready")
+			close(dd.scanned)
+		})
+	}
+}
+
+// holdQuery waits, for queries in the zones of wait_ready, until the first
+// scan of every endpoint completes, so that existing containers aren't
+// looked up upstream meanwhile. Queries are answered as usual once the scan
+// completes, the wait timed out, or the query was canceled.
+func (dd *DockerDiscovery) holdQuery(ctx context.Context, qname string) {
+	if dd.holdTimeout == 0 || plugin.Zones(dd.holdZones).Matches(qname) == "" {
+		return
+	}
+	select {
+	case <-dd.scanned:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(dd.holdTimeout)
+	defer timer.Stop()
+	select {
+	case <-dd.scanned:
+	case <-timer.C:
+		log.Printf("[docker] Query for %s timed out waiting for the initial scan, answering without it", qname)
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,145 @@
+package dockerdiscovery
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReady(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	domain docker.loc
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+
+	var eps []*dockerEndpoint
+	var fakes []*fakeDockerAPI
+	for _, name := range []string{"", "node2"} {
+		fake := newFakeDockerAPI()
+		server := httptest.NewServer(fake)
+		t.Cleanup(func() {
+			server.CloseClientConnections()
+			server.Close()
+		})
+		client, err := dockerapi.NewClient(server.URL)
+		assert.Nil(t, err)
+		eps = append(eps, &dockerEndpoint{name: name, url: server.URL, client: client})
+		fakes = append(fakes, fake)
+	}
+	dd.endpoints = eps[1:]
+	fakes[0].set(genRunningContainer("aa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "web", "172.17.0.2"))
+	assert.False(t, dd.Ready())
+
+	// Ready once both endpoints are scanned
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		dd.supervise(eps[0], stop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+	assert.Eventually(t, func() bool { return len(dd.addressesByDomain("web.docker.loc.", false)) > 0 }, 2*time.Second, 10*time.Millisecond)
+	assert.False(t, dd.Ready())
+	go dd.supervise(eps[1], stop)
+	assert.Eventually(t, dd.Ready, 2*time.Second, 10*time.Millisecond)
+
+	// Not ready while an endpoint reconnects
+	fakes[1].restart()
+	assert.Eventually(t, func() bool { return !dd.Ready() }, 2*time.Second, 5*time.Millisecond)
+	assert.Eventually(t, dd.Ready, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestWaitReadyConfig(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	wait_ready 3s docker.loc Swarm.Loc.
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 3*time.Second, dd.holdTimeout)
+	assert.Equal(t, []string{"docker.loc.", "swarm.loc."}, dd.holdZones)
+
+	// The zones of the server block by default
+	c = caddy.NewTestController("dns", `docker {
+	wait_ready 3s
+}`)
+	c.ServerBlockKeys = []string{"docker.loc."}
+	dd, err = createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"docker.loc."}, dd.holdZones)
+
+	for _, config := range []string{"wait_ready", "wait_ready 0s", "wait_ready soon"} {
+		c = caddy.NewTestController("dns", "docker {\n\t"+config+"\n}")
+		_, err = createPlugin(c)
+		assert.NotNil(t, err, config)
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	domain docker.loc
+	wait_ready 50ms docker.loc
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Nil(t, dd.updateContainerInfo(genRunningContainer("aa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "web", "172.17.0.2")))
+
+	query := func(name string) (int, *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		rcode, _ := dd.ServeDNS(context.Background(), rec, m)
+		return rcode, rec.Msg
+	}
+
+	// Other zones aren't held
+	start := time.Now()
+	dd.holdQuery(context.Background(), "example.org.")
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	// Queries are answered as usual when the scan takes too long
+	start = time.Now()
+	rcode, msg := query("web.docker.loc.")
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Equal(t, dns.RcodeSuccess, rcode)
+	if assert.NotNil(t, msg) {
+		assert.Len(t, msg.Answer, 1)
+	}
+	dd.Next = test.NextHandler(dns.RcodeNameError, nil)
+	rcode, _ = query("missing.docker.loc.")
+	assert.Equal(t, dns.RcodeNameError, rcode)
+
+	// Held queries are answered once the scan completes
+	dd.holdTimeout = 5 * time.Second
+	answered := make(chan *dns.Msg)
+	go func() {
+		_, msg := query("web.docker.loc.")
+		answered <- msg
+	}()
+	time.Sleep(20 * time.Millisecond)
+	dd.setConnected(dd.primaryEndpoint(), true)
+	select {
+	case msg := <-answered:
+		if assert.NotNil(t, msg) {
+			assert.Len(t, msg.Answer, 1)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("query still held after the scan")
+	}
+
+	// Reconnections don't hold queries again
+	dd.setConnected(dd.primaryEndpoint(), false)
+	rcode, _ = query("web.docker.loc.")
+	assert.Equal(t, dns.RcodeSuccess, rcode)
+}
@@ -1,10 +1,16 @@
 package dockerdiscovery
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"net"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
+	"unicode"
 
 	dockerapi "github.com/fsouza/go-dockerclient"
 )
@@ -35,26 +41,68 @@ func (resolver SubDomainHostResolver) resolve(container *dockerapi.Container) ([
 	return domains, nil
 }
 
+// LabelResolver reads names from a container label. The label may hold
+// several names separated by commas or spaces, and further names can be
+// given in indexed labels: <label>.1, <label>.2, ...
 type LabelResolver struct {
 	hostLabel string
 }
 
 func (resolver LabelResolver) resolve(container *dockerapi.Container) ([]string, error) {
 	var domains []string
+	seen := make(map[string]bool)
 
-	for label, value := range container.Config.Labels {
-		if label == resolver.hostLabel {
-			domains = append(domains, value)
-			break
+	add := func(label, value string) {
+		for _, name := range splitNameList(value) {
+			name = strings.TrimSuffix(name, ".")
+			if !isValidDNSName(name) {
+				log.Printf("[docker] Ignoring invalid name %q in label %s of container %s", name, label, shortID(container.ID))
+				continue
+			}
+			if !seen[name] {
+				seen[name] = true
+				domains = append(domains, name)
+			}
 		}
 	}
 
+	add(resolver.hostLabel, container.Config.Labels[resolver.hostLabel])
+
+	// Indexed labels, in numeric order
+	type indexedLabel struct {
+		index int
+		label string
+	}
+	var indexed []indexedLabel
+	prefix := resolver.hostLabel + "."
+	for label := range container.Config.Labels {
+		if !strings.HasPrefix(label, prefix) {
+			continue
+		}
+		if index, err := strconv.Atoi(label[len(prefix):]); err == nil {
+			indexed = append(indexed, indexedLabel{index: index, label: label})
+		}
+	}
+	sort.Slice(indexed, func(i, j int) bool { return indexed[i].index < indexed[j].index })
+	for _, il := range indexed {
+		add(il.label, container.Config.Labels[il.label])
+	}
+
 	return domains, nil
 }
 
-// ComposeResolver sets names based on compose labels
+// splitNameList splits a list of names separated by commas and/or whitespace.
+func splitNameList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// ComposeResolver sets names based on compose labels: service.project.domain
+// for every replica, plus n.service.project.domain using the replica number.
 type ComposeResolver struct {
-	domain string
+	domain        string
+	includeOneoff bool // also name containers created by `docker compose run`
 }
 
 func (resolver ComposeResolver) resolve(container *dockerapi.Container) ([]string, error) {
@@ -66,10 +114,19 @@ func (resolver ComposeResolver) resolve(container *dockerapi.Container) ([]strin
 		return domains, nil
 	}
 
+	// One-off containers would otherwise join the service's aggregate name
+	if !resolver.includeOneoff && strings.EqualFold(container.Config.Labels["com.docker.compose.oneoff"], "true") {
+		return domains, nil
+	}
+
 	domain := fmt.Sprintf("%s.%s.%s", service, project, resolver.domain)
 	domains = append(domains, domain)
 
-	log.Printf("[docker] Found compose domain for container %s: %s", container.ID[:12], domain)
+	if number := container.Config.Labels["com.docker.compose.container-number"]; number != "" {
+		domains = append(domains, fmt.Sprintf("%s.%s", number, domain))
+	}
+
+	log.Printf("[docker] Found compose domains for container %s: %v", shortID(container.ID), domains)
 	return domains, nil
 }
 
@@ -95,11 +152,33 @@ func (resolver NetworkAliasesResolver) resolve(container *dockerapi.Container) (
 }
 
 // TraefikLabelResolver extracts hostnames from Traefik Docker labels.
-// It looks for labels matching traefik.http.routers.*.rule and extracts
-// Host() and HostSNI() values, similar to how coredns-traefik parses
+// It looks for labels matching traefik.http.routers.*.rule and
+// traefik.tcp.routers.*.rule and parses the rule for the Host() and
+// HostSNI() values it can match, similar to how coredns-traefik reads
 // Traefik's API response.
 type TraefikLabelResolver struct {
+	// Fallback for rules the parser rejects
 	hostMatcher *regexp.Regexp
+
+	// UDP routers have no rule, so their names come from this label
+	// (set to <label_prefix>.traefik_udp_host during setup).
+	udpHostLabel string
+
+	// Equivalents of Traefik's Docker provider settings: containers
+	// without traefik.enable are only published when exposedByDefault,
+	// and HTTP routers without a rule get defaultRule (if set).
+	exposedByDefault bool
+	defaultRule      *template.Template
+
+	// When not empty, only routers on one of these entrypoints (or
+	// without explicit entrypoints) are published.
+	entrypoints map[string]bool
+
+	// Also publish the tls.domains[n].main/sans names of routers
+	tlsDomains bool
+
+	// Also read Traefik 1.x frontend labels (traefik.frontend.rule, ...)
+	v1 bool
 }
 
 // traefikHostMatcher matches Host(`example.com`) and HostSNI(`example.com`) patterns
@@ -107,39 +186,258 @@ var traefikHostMatcher = regexp.MustCompile("Host(?:SNI)?\\(`([^`]+)`\\)")
 
 func NewTraefikLabelResolver() *TraefikLabelResolver {
 	return &TraefikLabelResolver{
-		hostMatcher: traefikHostMatcher,
+		hostMatcher:      traefikHostMatcher,
+		udpHostLabel:     defaultLabelPrefix + ".traefik_udp_host",
+		exposedByDefault: true,
+		entrypoints:      make(map[string]bool),
 	}
 }
 
+// traefikRouter is a router declared with traefik.<protocol>.routers.<name>.* labels.
+type traefikRouter struct {
+	protocol    string // "http", "tcp" or "udp"
+	name        string
+	rule        string
+	entrypoints []string
+	tlsDomains  []string // tls.domains[n].main and .sans, in declaration order
+}
+
+// traefikTLSDomainOption matches the tls.domains[n].main and
+// tls.domains[n].sans router options.
+var traefikTLSDomainOption = regexp.MustCompile(`^tls\.domains\[(\d+)\]\.(main|sans)$`)
+
+// traefikHost is a hostname published for a Traefik router.
+type traefikHost struct {
+	name        string
+	protocol    string   // protocol of the router the host was found in
+	entrypoints []string // entrypoints of that router, empty meaning all
+}
+
+// traefikRouters collects the routers declared in the labels, sorted by
+// protocol (http, tcp, udp) and name.
+func traefikRouters(labels map[string]string) []*traefikRouter {
+	routers := make(map[string]*traefikRouter)
+	type tlsDomain struct {
+		index int
+		sans  bool
+		names []string
+	}
+	tlsDomains := make(map[*traefikRouter][]tlsDomain)
+	for label, value := range labels {
+		// traefik.<protocol>.routers.<name>.<option>
+		parts := strings.SplitN(label, ".", 5)
+		if len(parts) != 5 || parts[0] != "traefik" || parts[2] != "routers" {
+			continue
+		}
+		protocol, name, option := parts[1], parts[3], parts[4]
+		if protocol != "http" && protocol != "tcp" && protocol != "udp" {
+			continue
+		}
+
+		key := protocol + "." + name
+		router, ok := routers[key]
+		if !ok {
+			router = &traefikRouter{protocol: protocol, name: name}
+			routers[key] = router
+		}
+		switch option {
+		case "rule":
+			router.rule = value
+		case "entrypoints":
+			router.entrypoints = splitNameList(value)
+		default:
+			if m := traefikTLSDomainOption.FindStringSubmatch(option); m != nil {
+				index, _ := strconv.Atoi(m[1])
+				tlsDomains[router] = append(tlsDomains[router], tlsDomain{index: index, sans: m[2] == "sans", names: splitNameList(value)})
+			}
+		}
+	}
+
+	result := make([]*traefikRouter, 0, len(routers))
+	for _, router := range routers {
+		domains := tlsDomains[router]
+		sort.Slice(domains, func(i, j int) bool {
+			if domains[i].index != domains[j].index {
+				return domains[i].index < domains[j].index
+			}
+			return !domains[i].sans && domains[j].sans
+		})
+		for _, domain := range domains {
+			router.tlsDomains = append(router.tlsDomains, domain.names...)
+		}
+		result = append(result, router)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].protocol != result[j].protocol {
+			return result[i].protocol < result[j].protocol
+		}
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
 func (resolver TraefikLabelResolver) resolve(container *dockerapi.Container) ([]string, error) {
 	var domains []string
+	for _, host := range resolver.resolveHosts(container) {
+		domains = append(domains, host.name)
+	}
+	return domains, nil
+}
+
+// resolveHosts returns the hosts of all routers of the container, each
+// once. A host found in several routers keeps the first one, so HTTP
+// routers win over TCP and UDP routers.
+func (resolver TraefikLabelResolver) resolveHosts(container *dockerapi.Container) []traefikHost {
+	var hosts []traefikHost
 	seen := make(map[string]bool)
+	add := func(name string, router *traefikRouter) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		hosts = append(hosts, traefikHost{name: name, protocol: router.protocol, entrypoints: router.entrypoints})
+		log.Printf("[docker] Found traefik %s host for container %s: %s", router.protocol, shortID(container.ID), name)
+	}
+
+	if !resolver.enabled(container.Config.Labels) {
+		return hosts
+	}
 
-	for label, value := range container.Config.Labels {
-		if !isTraefikRouterRule(label) {
+	for _, router := range resolver.routers(container) {
+		if !resolver.onEntrypoints(router) {
 			continue
 		}
-
-		matches := resolver.hostMatcher.FindAllStringSubmatch(value, -1)
-		for _, match := range matches {
-			if len(match) >= 2 {
-				host := strings.ToLower(match[1])
-				if !seen[host] {
-					seen[host] = true
-					domains = append(domains, host)
-					log.Printf("[docker] Found traefik host for container %s: %s", container.ID[:12], host)
+		if router.protocol == "udp" {
+			// UDP routers can't match on a name: publish the hint label
+			for _, name := range splitNameList(container.Config.Labels[resolver.udpHostLabel]) {
+				if isValidDNSName(name) {
+					add(strings.ToLower(name), router)
 				}
 			}
+			continue
+		}
+
+		for _, host := range resolver.routerHosts("container "+shortID(container.ID), router) {
+			add(host, router)
 		}
 	}
 
-	return domains, nil
+	return hosts
+}
+
+// enabled reports whether Traefik would serve the container, following
+// the traefik.enable label and exposedByDefault.
+func (resolver TraefikLabelResolver) enabled(labels map[string]string) bool {
+	enable, ok := labels["traefik.enable"]
+	if !ok {
+		return resolver.exposedByDefault
+	}
+	value, err := strconv.ParseBool(enable)
+	return err == nil && value
+}
+
+// routers returns the routers of the container (and its v1 frontends, in
+// v1 mode) with Traefik's defaultRule applied: to HTTP routers without a
+// rule, or, when the container declares no router at all, to an implicit
+// HTTP router named after the container.
+func (resolver TraefikLabelResolver) routers(container *dockerapi.Container) []*traefikRouter {
+	routers := traefikRouters(container.Config.Labels)
+	invalid := 0
+	if resolver.v1 {
+		var v1Routers []*traefikRouter
+		v1Routers, invalid = traefikV1Routers(container.Config.Labels)
+		routers = append(routers, v1Routers...)
+	}
+	if resolver.defaultRule == nil {
+		return routers
+	}
+
+	// Frontends left out for their invalid rule were declared
+	if len(routers) == 0 && invalid == 0 {
+		routers = append(routers, &traefikRouter{protocol: "http", name: normalizeDNSLabel(normalizeContainerName(container))})
+	}
+	for _, router := range routers {
+		if router.protocol != "http" || router.rule != "" {
+			continue
+		}
+		rule, err := resolver.executeDefaultRule(container)
+		if err != nil {
+			log.Printf("[docker] Error executing traefik_default_rule for container %s: %s", shortID(container.ID), err)
+			break
+		}
+		router.rule = rule
+	}
+	return routers
+}
+
+// executeDefaultRule renders defaultRule for the container. As in Traefik,
+// .Name is the container name, or "<service>-<project>" for compose
+// containers, normalized: runs of other characters than letters and digits
+// are replaced by dashes.
+func (resolver TraefikLabelResolver) executeDefaultRule(container *dockerapi.Container) (string, error) {
+	data := newContainerTemplateData(container)
+	if data.Compose.Service != "" && data.Compose.Project != "" {
+		data.Name = data.Compose.Service + "-" + data.Compose.Project
+	}
+	data.Name = normalizeDNSLabel(data.Name)
+	var buf bytes.Buffer
+	if err := resolver.defaultRule.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
-// isTraefikRouterRule checks if a Docker label is a Traefik HTTP router rule.
-// Matches labels like: traefik.http.routers.<name>.rule
-func isTraefikRouterRule(label string) bool {
-	return strings.HasPrefix(label, "traefik.http.routers.") && strings.HasSuffix(label, ".rule")
+// onEntrypoints reports whether the router listens on one of the configured
+// entrypoints. Routers without entrypoints listen on all of them.
+func (resolver TraefikLabelResolver) onEntrypoints(router *traefikRouter) bool {
+	if len(resolver.entrypoints) == 0 || len(router.entrypoints) == 0 {
+		return true
+	}
+	for _, entrypoint := range router.entrypoints {
+		if resolver.entrypoints[entrypoint] {
+			return true
+		}
+	}
+	return false
+}
+
+// routerHosts returns the hosts of an HTTP or TCP router: those its rule
+// can match and, with tlsDomains, the names of its certificates (wildcards
+// included). source tells where the router is declared, for logging.
+func (resolver TraefikLabelResolver) routerHosts(source string, router *traefikRouter) []string {
+	hosts := resolver.ruleHosts(source, router)
+	if resolver.tlsDomains {
+		for _, name := range router.tlsDomains {
+			if isValidDNSName(name) {
+				hosts = append(hosts, strings.ToLower(name))
+			}
+		}
+	}
+	return hosts
+}
+
+// ruleHosts returns the hosts the router's rule can match. Rules that fail
+// to parse fall back to scanning for Host() and HostSNI() matchers.
+func (resolver TraefikLabelResolver) ruleHosts(source string, router *traefikRouter) []string {
+	if router.rule == "" {
+		return nil
+	}
+
+	hosts, err := traefikRuleHosts(router.rule)
+	if err == nil {
+		return hosts
+	}
+	log.Printf("[docker] Could not parse traefik rule of router %s (%s): %s", router.name, source, err)
+
+	hosts = nil
+	for _, match := range resolver.hostMatcher.FindAllStringSubmatch(router.rule, -1) {
+		host := strings.ToLower(match[1])
+		// HostSNI(`*`) is the catch-all for non-TLS TCP routers
+		if host != "*" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
 }
 
 // getTraefikServicePort scans container labels for a Traefik service port
@@ -155,3 +453,96 @@ func getTraefikServicePort(labels map[string]string) string {
 	}
 	return ""
 }
+
+// CaddyLabelResolver extracts site addresses from caddy-docker-proxy
+// labels: caddy=app.example.com, and caddy_0, caddy_1, ... for further
+// sites. Schemes, ports and paths are stripped from the addresses.
+type CaddyLabelResolver struct{}
+
+// caddySiteLabel matches the caddy and caddy_N labels (not caddy.* directives)
+var caddySiteLabel = regexp.MustCompile(`^caddy(?:_(\d+))?$`)
+
+func (resolver CaddyLabelResolver) resolve(container *dockerapi.Container) ([]string, error) {
+	type siteLabel struct {
+		index int // -1 for the caddy label
+		label string
+	}
+	var labels []siteLabel
+	for label := range container.Config.Labels {
+		m := caddySiteLabel.FindStringSubmatch(label)
+		if m == nil {
+			continue
+		}
+		index := -1
+		if m[1] != "" {
+			index, _ = strconv.Atoi(m[1])
+		}
+		labels = append(labels, siteLabel{index: index, label: label})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].index < labels[j].index })
+
+	var domains []string
+	seen := make(map[string]bool)
+	for _, l := range labels {
+		for _, address := range splitNameList(container.Config.Labels[l.label]) {
+			host := caddySiteHost(address)
+			// Snippets, placeholders and catch-all addresses like :80 have no name
+			if host == "" || net.ParseIP(host) != nil || !isValidDNSName(host) {
+				continue
+			}
+			if !seen[host] {
+				seen[host] = true
+				domains = append(domains, host)
+				log.Printf("[docker] Found caddy host for container %s: %s", shortID(container.ID), host)
+			}
+		}
+	}
+	return domains, nil
+}
+
+// caddySiteHost returns the host of a Caddy site address like
+// https://app.example.com:8443/path, lowercased.
+func caddySiteHost(address string) string {
+	if i := strings.Index(address, "://"); i >= 0 {
+		address = address[i+3:]
+	}
+	if i := strings.Index(address, "/"); i >= 0 {
+		address = address[:i]
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return strings.ToLower(strings.TrimSuffix(address, "."))
+}
+
+// NginxProxyResolver reads the names of containers deployed for nginx-proxy
+// from their VIRTUAL_HOST and LETSENCRYPT_HOST environment variables.
+// Wildcards like *.example.com are kept (as wildcard records), while
+// example.* wildcards and ~regexp hosts can't be published and are ignored.
+type NginxProxyResolver struct{}
+
+func (resolver NginxProxyResolver) resolve(container *dockerapi.Container) ([]string, error) {
+	env := make(map[string]string)
+	for _, e := range container.Config.Env {
+		if key, value, ok := strings.Cut(e, "="); ok {
+			env[key] = value
+		}
+	}
+
+	var domains []string
+	seen := make(map[string]bool)
+	for _, variable := range []string{"VIRTUAL_HOST", "LETSENCRYPT_HOST"} {
+		for _, host := range splitNameList(env[variable]) {
+			host = strings.ToLower(strings.TrimSuffix(host, "."))
+			if strings.HasPrefix(host, "~") || strings.HasSuffix(host, ".*") || !isValidDNSName(host) {
+				continue
+			}
+			if !seen[host] {
+				seen[host] = true
+				domains = append(domains, host)
+				log.Printf("[docker] Found nginx-proxy host for container %s: %s", shortID(container.ID), host)
+			}
+		}
+	}
+	return domains, nil
+}
@@ -0,0 +1,130 @@
+package dockerdiscovery
+
+import (
+	"log"
+	"sort"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+// Kinds of differences fixed by resyncs.
+const (
+	resyncAdded   = "added"   // running container without entry
+	resyncRemoved = "removed" // entry of a container that isn't running
+	resyncUpdated = "updated" // entry that doesn't match its container
+)
+
+// resync converges the entries of the endpoint to the state of Docker, in
+// case events were missed or handled out of order: running containers are
+// inspected anew and their entries added or updated, entries of containers
+// that aren't running are removed. Fixes are queued like the work of events,
+// which they can't undo, and are done when resync returns. It returns the
+// number of differences fixed. Overlapping resyncs of an endpoint are
+// skipped.
+func (dd *DockerDiscovery) resync(ep *dockerEndpoint) (int, error) {
+	if !ep.resyncMutex.TryLock() {
+		return 0, nil
+	}
+	defer ep.resyncMutex.Unlock()
+
+	containers, err := ep.client.ListContainers(dockerapi.ListContainersOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	fixed := 0
+	var tasks []<-chan struct{}
+	running := make(map[string]bool)
+	for _, apiContainer := range containers {
+		container, err := ep.client.InspectContainerWithOptions(dockerapi.InspectContainerOptions{ID: apiContainer.ID})
+		if err != nil {
+			// Kept as it is until the next resync
+			log.Printf("[docker] Resync: failed to inspect container %s: %s", shortID(apiContainer.ID), err)
+			running[apiContainer.ID] = true
+			continue
+		}
+		if !container.State.Running {
+			continue
+		}
+		running[container.ID] = true
+
+		desired := dd.resolveContainerInfo(ep, container)
+		dd.mutex.RLock()
+		current := dd.containerInfoMap[ep.key(container.ID)]
+		dd.mutex.RUnlock()
+
+		var kind string
+		switch {
+		case current == nil && desired == nil:
+			continue
+		case current == nil:
+			kind = resyncAdded
+		case desired == nil:
+			kind = resyncRemoved
+		case !sameContainerInfo(current, desired):
+			kind = resyncUpdated
+		default:
+			continue
+		}
+		log.Printf("[docker] Resync of %s: %s entry of container %s (%s)", ep.describe(), kind, normalizeContainerName(container), shortID(container.ID))
+		resyncFixes.WithLabelValues(ep.name, kind).Inc()
+		fixed++
+		tasks = append(tasks, dd.queueSync(ep, container.ID, "resync"))
+	}
+
+	stale := dd.removeStaleEntries(ep, running)
+	tasks = append(tasks, stale...)
+	waitTasks(tasks, nil)
+	if removed := len(stale); removed > 0 {
+		log.Printf("[docker] Resync of %s: removed %d entries of containers that aren't running", ep.describe(), removed)
+		resyncFixes.WithLabelValues(ep.name, resyncRemoved).Add(float64(removed))
+		fixed += removed
+	}
+
+	// Swarm services and pods are listed as a whole anyway
+	if ep.name == "" && dd.swarmDomain != "" {
+		if err := dd.syncSwarm(); err != nil {
+			log.Printf("[docker] Resync: failed to list swarm services: %s", err)
+		}
+	}
+	if ep.name == "" && dd.podDomain != "" {
+		if err := dd.syncPods(); err != nil {
+			log.Printf("[docker] Resync: failed to list pods: %s", err)
+		}
+	}
+	return fixed, nil
+}
+
+// sameContainerInfo reports whether two entries publish the same records.
+func sameContainerInfo(a, b *ContainerInfo) bool {
+	if !a.address.Equal(b.address) || !a.address6.Equal(b.address6) || a.tunnelServiceURL != b.tunnelServiceURL {
+		return false
+	}
+	if !sameStrings(a.domains, b.domains) || !sameStrings(a.cnameDomains, b.cnameDomains) || len(a.cnameTargets) != len(b.cnameTargets) {
+		return false
+	}
+	for d, target := range a.cnameTargets {
+		other, ok := b.cnameTargets[d]
+		if !ok || target.cname != other.cname || !target.a.Equal(other.a) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameStrings reports whether two slices hold the same strings, in any order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package dockerdiscovery
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResync(t *testing.T) {
+	fake := newFakeDockerAPI()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	c := caddy.NewTestController("dns", `docker {
+	domain docker.loc
+	resync 30s
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 30*time.Second, dd.resyncInterval)
+	client, err := dockerapi.NewClient(server.URL)
+	assert.Nil(t, err)
+	ep := &dockerEndpoint{url: server.URL, client: client}
+
+	web := genRunningContainer("aa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "web", "172.17.0.2")
+	db := genRunningContainer("bb155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "db", "172.17.0.3")
+	fake.set(web, db)
+
+	// Missed events: web's start, db's restart with a new address and
+	// ghost's death
+	staleDB := genRunningContainer(db.ID, "db", "172.17.0.9")
+	ghost := genRunningContainer("cc155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "ghost", "172.17.0.4")
+	assert.Nil(t, dd.updateEndpointContainerInfo(ep, staleDB))
+	assert.Nil(t, dd.updateEndpointContainerInfo(ep, ghost))
+
+	count := func(kind string) float64 { return testutil.ToFloat64(resyncFixes.WithLabelValues("", kind)) }
+	added, removed, updated := count(resyncAdded), count(resyncRemoved), count(resyncUpdated)
+
+	fixed, err := dd.resync(ep)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, fixed)
+	assert.Equal(t, added+1, count(resyncAdded))
+	assert.Equal(t, removed+1, count(resyncRemoved))
+	assert.Equal(t, updated+1, count(resyncUpdated))
+
+	assert.Equal(t, "172.17.0.2", dd.addressesByDomain("web.docker.loc.", false)[0].String())
+	assert.Equal(t, "172.17.0.3", dd.addressesByDomain("db.docker.loc.", false)[0].String())
+	assert.Empty(t, dd.addressesByDomain("ghost.docker.loc.", false))
+
+	// Converged
+	fixed, err = dd.resync(ep)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, fixed)
+
+	for _, config := range []string{"resync", "resync 0s", "resync soon"} {
+		c = caddy.NewTestController("dns", "docker {\n\t"+config+"\n}")
+		_, err = createPlugin(c)
+		assert.NotNil(t, err, config)
+	}
+}
+
+func TestSameContainerInfo(t *testing.T) {
+	info := func() *ContainerInfo {
+		return &ContainerInfo{
+			address:      []byte{172, 17, 0, 2},
+			domains:      []string{"a.lan", "b.lan"},
+			cnameDomains: []string{"app.example.com"},
+			cnameTargets: map[string]recordTarget{"app.example.com": {cname: "traefik.lan"}},
+		}
+	}
+	a, b := info(), info()
+	b.domains = []string{"b.lan", "a.lan"}
+	assert.True(t, sameContainerInfo(a, b))
+
+	b.address6 = []byte{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	assert.False(t, sameContainerInfo(a, b))
+	b = info()
+	b.cnameTargets["app.example.com"] = recordTarget{cname: "node2.lan"}
+	assert.False(t, sameContainerInfo(a, b))
+	b = info()
+	b.domains = []string{"a.lan"}
+	assert.False(t, sameContainerInfo(a, b))
+}
@@ -0,0 +1,92 @@
+package dockerdiscovery
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+// NameRewriteRule rewrites resolved names matching a regular expression,
+// e.g. "^(\w+)-(\w+)-\d+\.docker\.local$" -> "$2.$1.docker.local" turns
+// compose v2 names like myapp-web-1.docker.local into web.myapp.docker.local.
+type NameRewriteRule struct {
+	pattern      *regexp.Regexp
+	replacement  string // may reference capture groups ($1, ${name})
+	keepOriginal bool   // also publish the name as it was before this rule
+}
+
+// NewNameRewriteRule compiles pattern into a rewrite rule.
+func NewNameRewriteRule(pattern, replacement string, keepOriginal bool) (*NameRewriteRule, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &NameRewriteRule{pattern: re, replacement: replacement, keepOriginal: keepOriginal}, nil
+}
+
+// rewriteNames applies the rules in order to every name; the output of one
+// rule is the input of the next. Rewrites that produce an invalid DNS name
+// are discarded and the name is kept as it was. The result is deduplicated.
+func rewriteNames(rules []*NameRewriteRule, names []string) []string {
+	if len(rules) == 0 {
+		return names
+	}
+
+	var result []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+
+	for _, name := range names {
+		current := name
+		for _, rule := range rules {
+			if !rule.pattern.MatchString(current) {
+				continue
+			}
+			rewritten := strings.TrimSuffix(rule.pattern.ReplaceAllString(current, rule.replacement), ".")
+			if !isValidDNSName(rewritten) {
+				log.Printf("[docker] name_rewrite %q turned %s into invalid name %q, ignoring", rule.pattern, current, rewritten)
+				continue
+			}
+			if rule.keepOriginal {
+				add(current)
+			}
+			current = rewritten
+		}
+		add(current)
+	}
+	return result
+}
+
+// isValidDNSName reports whether name (without trailing dot) is usable as a
+// record name: at most 253 characters of non-empty labels up to 63
+// characters made of letters, digits, '-' and '_', not starting or ending
+// with '-'. A leading "*" label (wildcard) is allowed.
+func isValidDNSName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for i, label := range strings.Split(name, ".") {
+		if i == 0 && label == "*" {
+			continue
+		}
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package dockerdiscovery
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteNames(t *testing.T) {
+	compose, err := NewNameRewriteRule(`^([a-z0-9]+)-([a-z0-9]+)-[0-9]+\.docker\.local$`, "$2.$1.docker.local", false)
+	assert.Nil(t, err)
+	keep, err := NewNameRewriteRule(`\.docker\.local$`, ".lan", true)
+	assert.Nil(t, err)
+	invalid, err := NewNameRewriteRule(`^web`, "-web", false)
+	assert.Nil(t, err)
+
+	// no rules: names pass through untouched
+	assert.Equal(t, []string{"a.docker.local"}, rewriteNames(nil, []string{"a.docker.local"}))
+
+	// compose v2 name rewritten, other names untouched
+	assert.Equal(t,
+		[]string{"web.myapp.docker.local", "plain.docker.local"},
+		rewriteNames([]*NameRewriteRule{compose}, []string{"myapp-web-1.docker.local", "plain.docker.local"}))
+
+	// rules are chained in order, keep_original keeps the input of that rule
+	assert.Equal(t,
+		[]string{"web.myapp.docker.local", "web.myapp.lan"},
+		rewriteNames([]*NameRewriteRule{compose, keep}, []string{"myapp-web-1.docker.local"}))
+
+	// invalid results are discarded, the name stays as it was
+	assert.Equal(t,
+		[]string{"web.myapp.docker.local"},
+		rewriteNames([]*NameRewriteRule{compose, invalid}, []string{"myapp-web-1.docker.local"}))
+
+	// names collapsing onto the same result are deduplicated
+	assert.Equal(t,
+		[]string{"web.myapp.docker.local"},
+		rewriteNames([]*NameRewriteRule{compose}, []string{"myapp-web-1.docker.local", "myapp-web-2.docker.local"}))
+}
+
+func TestIsValidDNSName(t *testing.T) {
+	assert.True(t, isValidDNSName("web.myapp.lan"))
+	assert.True(t, isValidDNSName("evil_ptolemy.docker.loc"))
+	assert.True(t, isValidDNSName("*.example.com"))
+	assert.True(t, isValidDNSName("localhost"))
+	assert.False(t, isValidDNSName(""))
+	assert.False(t, isValidDNSName("a..b"))
+	assert.False(t, isValidDNSName("-web.lan"))
+	assert.False(t, isValidDNSName("web-.lan"))
+	assert.False(t, isValidDNSName("web app.lan"))
+	assert.False(t, isValidDNSName("a.*.lan"))
+	assert.False(t, isValidDNSName("app.example.com/path"))
+}
+
+func TestNameRewriteConfig(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	domain docker.local
+	name_rewrite ^([a-z0-9]+)-([a-z0-9]+)-[0-9]+\.docker\.local$ $2.$1.docker.local keep_original
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(dd.nameRewrites))
+
+	container := &dockerapi.Container{
+		ID:   "fa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7",
+		Name: "/myapp-web-1",
+		Config: &dockerapi.Config{
+			Labels: map[string]string{},
+		},
+		HostConfig: &dockerapi.HostConfig{},
+		NetworkSettings: &dockerapi.NetworkSettings{
+			IPAddress: "172.17.0.5",
+		},
+	}
+	assert.Nil(t, dd.updateContainerInfo(container))
+
+	result, err := dd.containerInfoByDomain("web.myapp.docker.local.")
+	assert.Nil(t, err)
+	assert.NotNil(t, result)
+	result, err = dd.containerInfoByDomain("myapp-web-1.docker.local.")
+	assert.Nil(t, err)
+	assert.NotNil(t, result)
+}
+
+func TestNameRewriteConfigInvalid(t *testing.T) {
+	for _, block := range []string{
+		"name_rewrite ^a",
+		"name_rewrite ^(a b",
+		"name_rewrite ([ b",
+		"name_rewrite ^a b keep",
+		"name_rewrite ^a b keep_original extra",
+	} {
+		c := caddy.NewTestController("dns", "docker {\n"+block+"\n}")
+		_, err := createPlugin(c)
+		assert.NotNil(t, err, block)
+	}
+}
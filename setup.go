@@ -4,19 +4,21 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
 
-	dockerapi "github.com/fsouza/go-dockerclient"
-
 	"github.com/coredns/caddy"
 )
 
 const defaultDockerEndpoint = "unix:///var/run/docker.sock"
 const defaultDockerDomain = "docker.local"
+const defaultLabelPrefix = "coredns.dockerdiscovery"
 
 func init() {
 	caddy.RegisterPlugin("docker", caddy.Plugin{
@@ -28,13 +30,26 @@ func init() {
 // TODO(kevinjqiu): add docker endpoint verification
 func createPlugin(c *caddy.Controller) (*DockerDiscovery, error) {
 	dd := NewDockerDiscovery(defaultDockerEndpoint)
-	labelResolver := &LabelResolver{hostLabel: "coredns.dockerdiscovery.host"}
+	labelResolver := &LabelResolver{}
 	dd.resolvers = append(dd.resolvers, labelResolver)
+	// Resolver for the hostname label, created by cname_target
+	var hostnameResolver *LabelResolver
+	// Configured by the traefik_* options, enabled by traefik_cname,
+	// traefik_a, traefik_tcp_* or the Cloudflare settings
+	traefikResolver := NewTraefikLabelResolver()
+	// Targets of caddy-docker-proxy and nginx-proxy hosts, set by
+	// caddy_cname/caddy_a and nginx_proxy_cname/nginx_proxy_a
+	var caddyTarget, nginxProxyTarget *recordTarget
+	// Set by tls_ca, tls_cert and tls_key
+	var tlsFiles *dockerTLSFiles
+	// Without an endpoint argument, DOCKER_HOST or the docker context is used
+	endpointSet := false
 
 	for c.Next() {
 		args := c.RemainingArgs()
 		if len(args) == 1 && args[0] != "" {
 			dd.dockerEndpoint = args[0]
+			endpointSet = true
 		}
 
 		if len(args) > 1 {
@@ -72,6 +87,14 @@ func createPlugin(c *caddy.Controller) (*DockerDiscovery, error) {
 					return dd, c.ArgErr()
 				}
 				resolver.domain = c.Val()
+				for c.NextArg() {
+					switch c.Val() {
+					case "include_oneoff":
+						resolver.includeOneoff = true
+					default:
+						return dd, c.Errf("unknown compose_domain option: '%s'", c.Val())
+					}
+				}
 			case "network_aliases":
 				var resolver = &NetworkAliasesResolver{
 					network: "",
@@ -81,18 +104,103 @@ func createPlugin(c *caddy.Controller) (*DockerDiscovery, error) {
 					return dd, c.ArgErr()
 				}
 				resolver.network = c.Val()
+			case "name_template":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return dd, c.ArgErr()
+				}
+				// Unquoted templates like {{ .Name }}.lan arrive split on spaces
+				resolver, err := NewTemplateResolver(strings.Join(args, " "))
+				if err != nil {
+					return dd, c.Errf("invalid name_template: %s", err)
+				}
+				dd.resolvers = append(dd.resolvers, resolver)
+			case "name_rewrite":
+				args := c.RemainingArgs()
+				if len(args) < 2 || len(args) > 3 {
+					return dd, c.ArgErr()
+				}
+				keepOriginal := false
+				if len(args) == 3 {
+					if args[2] != "keep_original" {
+						return dd, c.Errf("unknown name_rewrite option: '%s'", args[2])
+					}
+					keepOriginal = true
+				}
+				rule, err := NewNameRewriteRule(args[0], args[1], keepOriginal)
+				if err != nil {
+					return dd, c.Errf("invalid name_rewrite pattern: %s", err)
+				}
+				dd.nameRewrites = append(dd.nameRewrites, rule)
+			case "expose_by_default":
+				if !c.NextArg() {
+					return dd, c.ArgErr()
+				}
+				expose, err := strconv.ParseBool(c.Val())
+				if err != nil {
+					return dd, c.Errf("invalid value for expose_by_default: '%s'", c.Val())
+				}
+				dd.filter.exposeByDefault = expose
+			case "include_label":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return dd, c.ArgErr()
+				}
+				for _, arg := range args {
+					dd.filter.includeLabels = append(dd.filter.includeLabels, parseLabelMatcher(arg))
+				}
+			case "exclude_image":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return dd, c.ArgErr()
+				}
+				for _, arg := range args {
+					re, err := compileImageGlob(arg)
+					if err != nil {
+						return dd, c.Errf("invalid exclude_image pattern '%s': %s", arg, err)
+					}
+					dd.filter.excludeImages = append(dd.filter.excludeImages, re)
+				}
+			case "include_network":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return dd, c.ArgErr()
+				}
+				for _, arg := range args {
+					dd.filter.includeNetworks[arg] = true
+				}
+			case "include_compose_project":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return dd, c.ArgErr()
+				}
+				for _, arg := range args {
+					dd.filter.composeProjects[arg] = true
+				}
 			case "label":
 				if !c.NextArg() {
 					return dd, c.ArgErr()
 				}
 				labelResolver.hostLabel = c.Val()
+			case "label_prefix":
+				if !c.NextArg() {
+					return dd, c.ArgErr()
+				}
+				prefix := strings.TrimSuffix(c.Val(), ".")
+				if prefix == "" {
+					return dd, c.Errf("invalid label_prefix: '%s'", c.Val())
+				}
+				dd.labelPrefix = prefix
 			case "cname_target":
 				if !c.NextArg() || c.Val() == "" {
 					// Skip — CNAME_TARGET env var not set
 					continue
 				}
 				dd.traefikCNAME = c.Val()
-				dd.cnameResolvers = append(dd.cnameResolvers, &LabelResolver{hostLabel: "coredns.dockerdiscovery.hostname"})
+				if hostnameResolver == nil {
+					hostnameResolver = &LabelResolver{}
+					dd.cnameResolvers = append(dd.cnameResolvers, hostnameResolver)
+				}
 			case "traefik_cname":
 				if !c.NextArg() || c.Val() == "" {
 					// Skip — TRAEFIK_HOST env var not set
@@ -102,7 +210,7 @@ func createPlugin(c *caddy.Controller) (*DockerDiscovery, error) {
 					return dd, c.Err("traefik_cname and traefik_a are mutually exclusive")
 				}
 				dd.traefikCNAME = c.Val()
-				dd.traefikResolver = NewTraefikLabelResolver()
+				dd.traefikResolver = traefikResolver
 			case "traefik_a":
 				if !c.NextArg() {
 					return dd, c.ArgErr()
@@ -115,7 +223,191 @@ func createPlugin(c *caddy.Controller) (*DockerDiscovery, error) {
 					return dd, c.Errf("invalid IP address for traefik_a: '%s'", c.Val())
 				}
 				dd.traefikA = ip
-				dd.traefikResolver = NewTraefikLabelResolver()
+				dd.traefikResolver = traefikResolver
+			case "traefik_tcp_cname":
+				if !c.NextArg() || c.Val() == "" {
+					continue
+				}
+				if dd.traefikTCPTarget != nil && dd.traefikTCPTarget.a != nil {
+					return dd, c.Err("traefik_tcp_cname and traefik_tcp_a are mutually exclusive")
+				}
+				dd.traefikTCPTarget = &recordTarget{cname: c.Val()}
+				dd.traefikResolver = traefikResolver
+			case "traefik_tcp_a":
+				if !c.NextArg() {
+					return dd, c.ArgErr()
+				}
+				if dd.traefikTCPTarget != nil && dd.traefikTCPTarget.cname != "" {
+					return dd, c.Err("traefik_tcp_cname and traefik_tcp_a are mutually exclusive")
+				}
+				ip := net.ParseIP(c.Val())
+				if ip == nil {
+					return dd, c.Errf("invalid IP address for traefik_tcp_a: '%s'", c.Val())
+				}
+				dd.traefikTCPTarget = &recordTarget{a: ip}
+				dd.traefikResolver = traefikResolver
+			case "traefik_entrypoint":
+				args := c.RemainingArgs()
+				if len(args) != 2 || args[0] == "" || args[1] == "" {
+					return dd, c.ArgErr()
+				}
+				if dd.traefikEntrypointTargets == nil {
+					dd.traefikEntrypointTargets = make(map[string]recordTarget)
+				}
+				dd.traefikEntrypointTargets[args[0]] = parseRecordTarget(args[1])
+				dd.traefikResolver = traefikResolver
+			case "traefik_exposed_by_default":
+				if !c.NextArg() {
+					return dd, c.ArgErr()
+				}
+				exposed, err := strconv.ParseBool(c.Val())
+				if err != nil {
+					return dd, c.Errf("invalid value for traefik_exposed_by_default: '%s'", c.Val())
+				}
+				traefikResolver.exposedByDefault = exposed
+			case "traefik_default_rule":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return dd, c.ArgErr()
+				}
+				tmpl, err := newContainerTemplate("traefik_default_rule", strings.Join(args, " "))
+				if err != nil {
+					return dd, c.Errf("invalid traefik_default_rule: %s", err)
+				}
+				traefikResolver.defaultRule = tmpl
+			case "traefik_entrypoints":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return dd, c.ArgErr()
+				}
+				for _, arg := range args {
+					for _, entrypoint := range splitNameList(arg) {
+						traefikResolver.entrypoints[entrypoint] = true
+					}
+				}
+			case "traefik_tls_domains":
+				if c.NextArg() {
+					return dd, c.ArgErr()
+				}
+				traefikResolver.tlsDomains = true
+			case "caddy_cname", "caddy_a":
+				target, err := parseProxyTarget(c, caddyTarget)
+				if err != nil {
+					return dd, err
+				}
+				caddyTarget = target
+			case "nginx_proxy_cname", "nginx_proxy_a":
+				target, err := parseProxyTarget(c, nginxProxyTarget)
+				if err != nil {
+					return dd, err
+				}
+				nginxProxyTarget = target
+			case "swarm":
+				if !c.NextArg() || c.Val() == "" {
+					return dd, c.ArgErr()
+				}
+				dd.swarmDomain = strings.TrimSuffix(c.Val(), ".")
+			case "tls_ca", "tls_cert", "tls_key":
+				directive := c.Val()
+				if !c.NextArg() || c.Val() == "" {
+					return dd, c.ArgErr()
+				}
+				if _, err := os.Stat(c.Val()); err != nil {
+					return dd, c.Errf("%s: %s", directive, err)
+				}
+				if tlsFiles == nil {
+					tlsFiles = &dockerTLSFiles{}
+				}
+				switch directive {
+				case "tls_ca":
+					tlsFiles.ca = c.Val()
+				case "tls_cert":
+					tlsFiles.cert = c.Val()
+				case "tls_key":
+					tlsFiles.key = c.Val()
+				}
+			case "endpoint":
+				args := c.RemainingArgs()
+				// Certificate directory of the endpoint, after "tls"
+				var certDir string
+				if n := len(args); n >= 4 && args[n-2] == "tls" {
+					certDir = args[n-1]
+					args = args[:n-2]
+				}
+				if len(args) < 2 || len(args) > 3 {
+					return dd, c.ArgErr()
+				}
+				if args[0] == "" || strings.ContainsAny(args[0], "/:") {
+					return dd, c.Errf("invalid endpoint name '%s'", args[0])
+				}
+				for _, ep := range dd.endpoints {
+					if ep.name == args[0] {
+						return dd, c.Errf("duplicate endpoint name '%s'", args[0])
+					}
+				}
+				ep := &dockerEndpoint{name: args[0], url: args[1]}
+				if len(args) == 3 {
+					target := parseRecordTarget(args[2])
+					ep.target = &target
+				}
+				if certDir != "" {
+					if _, err := os.Stat(certDir); err != nil {
+						return dd, c.Errf("endpoint %s: %s", ep.name, err)
+					}
+					ep.tls = certDirTLSFiles(certDir)
+				}
+				dd.endpoints = append(dd.endpoints, ep)
+			case "resync":
+				if !c.NextArg() {
+					return dd, c.ArgErr()
+				}
+				interval, err := time.ParseDuration(c.Val())
+				if err != nil || interval <= 0 {
+					return dd, c.Errf("invalid resync interval '%s'", c.Val())
+				}
+				dd.resyncInterval = interval
+			case "wait_ready":
+				if !c.NextArg() {
+					return dd, c.ArgErr()
+				}
+				timeout, err := time.ParseDuration(c.Val())
+				if err != nil || timeout <= 0 {
+					return dd, c.Errf("invalid wait_ready timeout '%s'", c.Val())
+				}
+				dd.holdTimeout = timeout
+				dd.holdZones = plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), c.ServerBlockKeys)
+			case "podman_pods":
+				if !c.NextArg() || c.Val() == "" {
+					return dd, c.ArgErr()
+				}
+				dd.podDomain = strings.TrimSuffix(c.Val(), ".")
+			case "traefik_v1":
+				if c.NextArg() {
+					return dd, c.ArgErr()
+				}
+				traefikResolver.v1 = true
+			case "traefik_file":
+				if !c.NextArg() || c.Val() == "" {
+					return dd, c.ArgErr()
+				}
+				dd.traefikFile = NewTraefikFileProvider(c.Val())
+			case "traefik_api":
+				args := c.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return dd, c.ArgErr()
+				}
+				if u, err := url.Parse(args[0]); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+					return dd, c.Errf("invalid traefik_api URL: '%s'", args[0])
+				}
+				interval := defaultTraefikAPIInterval
+				if len(args) == 2 {
+					var err error
+					interval, err = time.ParseDuration(args[1])
+					if err != nil || interval <= 0 {
+						return dd, c.Errf("invalid traefik_api interval: '%s'", args[1])
+					}
+				}
+				dd.traefikAPI = NewTraefikAPIProvider(args[0], interval)
 			case "ttl":
 				if !c.NextArg() {
 					return dd, c.ArgErr()
@@ -213,6 +505,23 @@ func createPlugin(c *caddy.Controller) (*DockerDiscovery, error) {
 		}
 	}
 
+	// Labels are namespaced by label_prefix, which may appear after the
+	// directives using them. An explicit `label` keeps its full name.
+	if labelResolver.hostLabel == "" {
+		labelResolver.hostLabel = dd.label("host")
+	}
+	if hostnameResolver != nil {
+		hostnameResolver.hostLabel = dd.label("hostname")
+	}
+	dd.filter.enableLabel = dd.label("enable")
+	traefikResolver.udpHostLabel = dd.label("traefik_udp_host")
+	if caddyTarget != nil {
+		dd.proxyResolvers = append(dd.proxyResolvers, proxyResolver{resolver: CaddyLabelResolver{}, target: *caddyTarget})
+	}
+	if nginxProxyTarget != nil {
+		dd.proxyResolvers = append(dd.proxyResolvers, proxyResolver{resolver: NginxProxyResolver{}, target: *nginxProxyTarget})
+	}
+
 	// Cloudflare Tunnel initialization — only if fully configured
 	if dd.tunnelConfig != nil {
 		hasTunnelID := dd.tunnelConfig.TunnelID != ""
@@ -236,14 +545,14 @@ func createPlugin(c *caddy.Controller) (*DockerDiscovery, error) {
 				return dd, fmt.Errorf("tunnel: cf_tunnel_id requires %s", strings.Join(missing, ", "))
 			}
 
-			// Auto-enable traefik resolver if not already configured
-			if dd.traefikResolver == nil {
+			// Default to the tunnel unless a target is configured
+			if !dd.hasDefaultTarget() {
 				if dd.cloudflareConfig.TargetDomain != "" {
 					dd.traefikCNAME = dd.cloudflareConfig.TargetDomain
 				} else {
 					dd.traefikCNAME = fmt.Sprintf("%s.cfargotunnel.com", dd.tunnelConfig.TunnelID)
 				}
-				dd.traefikResolver = NewTraefikLabelResolver()
+				dd.traefikResolver = traefikResolver
 			}
 
 			tunnelSyncer, err := NewTunnelSyncer(dd.tunnelConfig, dd.cloudflareConfig)
@@ -272,10 +581,10 @@ func createPlugin(c *caddy.Controller) (*DockerDiscovery, error) {
 		hasZones := len(dd.cloudflareConfig.Zones) > 0
 
 		if hasCredentials && hasTarget && hasZones {
-			// Auto-enable traefik resolver if not already configured
-			if dd.traefikResolver == nil {
+			// Default to the Cloudflare target unless one is configured
+			if !dd.hasDefaultTarget() {
 				dd.traefikCNAME = dd.cloudflareConfig.TargetDomain
-				dd.traefikResolver = NewTraefikLabelResolver()
+				dd.traefikResolver = traefikResolver
 			}
 
 			syncer, err := NewCloudflareSyncer(dd.cloudflareConfig)
@@ -300,19 +609,90 @@ func createPlugin(c *caddy.Controller) (*DockerDiscovery, error) {
 		// If nothing meaningful was set (all empty from unset env vars), silently skip
 	}
 
-	dockerClient, err := dockerapi.NewClient(dd.dockerEndpoint)
+	if dd.traefikFile != nil && !dd.hasDefaultTarget() {
+		return dd, fmt.Errorf("traefik_file requires a traefik target (traefik_cname, traefik_a or cf_target)")
+	}
+	if dd.traefikAPI != nil && !dd.hasDefaultTarget() {
+		return dd, fmt.Errorf("traefik_api requires a traefik target (traefik_cname, traefik_a or cf_target)")
+	}
+
+	if tlsFiles != nil && (tlsFiles.cert == "") != (tlsFiles.key == "") {
+		return dd, fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	if !endpointSet {
+		endpoint, envTLSFiles, err := endpointFromEnv()
+		if err != nil {
+			return dd, err
+		}
+		if endpoint != "" {
+			dd.dockerEndpoint = endpoint
+			if tlsFiles == nil {
+				tlsFiles = envTLSFiles
+			}
+		}
+	}
+
+	dockerClient, err := newDockerClient(dd.dockerEndpoint, tlsFiles)
 	if err != nil {
 		return dd, err
 	}
 	dd.dockerClient = dockerClient
-	go func() {
-		if err := dd.start(); err != nil {
-			log.Printf("[docker] FATAL: plugin start() failed: %s", err)
+	for _, ep := range dd.endpoints {
+		epTLSFiles := tlsFiles
+		if ep.tls != nil {
+			epTLSFiles = ep.tls
+		}
+		if ep.client, err = newDockerClient(ep.url, epTLSFiles); err != nil {
+			return dd, fmt.Errorf("endpoint %s: %s", ep.name, err)
+		}
+	}
+	// Watchers run from startup, until the server shuts down or reloads
+	primary := dd.primaryEndpoint()
+	stop := make(chan struct{})
+	c.OnStartup(func() error {
+		go dd.start(primary, stop)
+		for _, ep := range dd.endpoints {
+			go dd.supervise(ep, stop)
+		}
+		if dd.traefikFile != nil {
+			go dd.watchTraefikFile(stop)
 		}
-	}()
+		if dd.traefikAPI != nil {
+			go dd.pollTraefikAPI(stop)
+		}
+		return nil
+	})
+	c.OnShutdown(func() error {
+		close(stop)
+		dd.queue.shutdown()
+		return nil
+	})
 	return dd, nil
 }
 
+// parseProxyTarget reads the argument of a <proxy>_cname or <proxy>_a
+// directive. current is the target set by an earlier one, if any: the two
+// are mutually exclusive.
+func parseProxyTarget(c *caddy.Controller, current *recordTarget) (*recordTarget, error) {
+	directive := c.Val()
+	proxy := directive[:strings.LastIndex(directive, "_")]
+	isCNAME := strings.HasSuffix(directive, "_cname")
+	if !c.NextArg() || c.Val() == "" {
+		return nil, c.ArgErr()
+	}
+	if current != nil && isCNAME != (current.cname != "") {
+		return nil, c.Errf("%s_cname and %s_a are mutually exclusive", proxy, proxy)
+	}
+	if isCNAME {
+		return &recordTarget{cname: c.Val()}, nil
+	}
+	ip := net.ParseIP(c.Val())
+	if ip == nil {
+		return nil, c.Errf("invalid IP address for %s: '%s'", directive, c.Val())
+	}
+	return &recordTarget{a: ip}, nil
+}
+
 func setup(c *caddy.Controller) error {
 	dd, err := createPlugin(c)
 	if err != nil {
@@ -1,12 +1,16 @@
 package dockerdiscovery
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"testing"
 
 	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
 	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -232,6 +236,43 @@ func TestTraefikLabelResolver(t *testing.T) {
 			},
 			expected: nil,
 		},
+		{
+			name: "tcp router",
+			labels: map[string]string{
+				"traefik.tcp.routers.pg.rule":        "HostSNI(`db.example.com`)",
+				"traefik.tcp.routers.pg.entrypoints": "postgres",
+			},
+			expected: []string{"db.example.com"},
+		},
+		{
+			name: "tcp catch-all is skipped",
+			labels: map[string]string{
+				"traefik.tcp.routers.mqtt.rule": "HostSNI(`*`)",
+			},
+			expected: nil,
+		},
+		{
+			name: "udp router without hint label",
+			labels: map[string]string{
+				"traefik.udp.routers.dns.entrypoints": "dns-udp",
+			},
+			expected: nil,
+		},
+		{
+			name: "udp router with hint label",
+			labels: map[string]string{
+				"traefik.udp.routers.dns.entrypoints":      "dns-udp",
+				"coredns.dockerdiscovery.traefik_udp_host": "ns.example.com, ns2.example.com",
+			},
+			expected: []string{"ns.example.com", "ns2.example.com"},
+		},
+		{
+			name: "udp hint label without udp router",
+			labels: map[string]string{
+				"coredns.dockerdiscovery.traefik_udp_host": "ns.example.com",
+			},
+			expected: nil,
+		},
 		{
 			name: "traefik enable but no router rule",
 			labels: map[string]string{
@@ -340,15 +381,6 @@ func TestTraefikCNAMEDomainResolution(t *testing.T) {
 	assert.Equal(t, address.String(), result.containerInfo.address.String())
 }
 
-func TestIsTraefikRouterRule(t *testing.T) {
-	assert.True(t, isTraefikRouterRule("traefik.http.routers.myapp.rule"))
-	assert.True(t, isTraefikRouterRule("traefik.http.routers.my-app-web.rule"))
-	assert.False(t, isTraefikRouterRule("traefik.http.routers.myapp.service"))
-	assert.False(t, isTraefikRouterRule("traefik.http.services.myapp.loadbalancer.server.port"))
-	assert.False(t, isTraefikRouterRule("traefik.enable"))
-	assert.False(t, isTraefikRouterRule("com.docker.compose.project"))
-}
-
 func TestCnameTargetConfig(t *testing.T) {
 	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
 	cname_target infra-1.homelab.local
@@ -574,3 +606,777 @@ func TestGetTraefikServicePort(t *testing.T) {
 	}
 	assert.Equal(t, "", getTraefikServicePort(labels))
 }
+
+func genComposeReplica(id string, number string, address string) *dockerapi.Container {
+	return &dockerapi.Container{
+		ID:   id,
+		Name: "/myapp-web-" + number,
+		Config: &dockerapi.Config{
+			Labels: map[string]string{
+				"com.docker.compose.project":          "myapp",
+				"com.docker.compose.service":          "web",
+				"com.docker.compose.container-number": number,
+			},
+		},
+		HostConfig: &dockerapi.HostConfig{
+			NetworkMode: "myapp_default",
+		},
+		NetworkSettings: &dockerapi.NetworkSettings{
+			Networks: map[string]dockerapi.ContainerNetwork{
+				"myapp_default": {IPAddress: address},
+			},
+		},
+	}
+}
+
+func TestComposeResolverReplicas(t *testing.T) {
+	resolver := ComposeResolver{domain: "compose.loc"}
+
+	domains, err := resolver.resolve(genComposeReplica("aa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "2", "172.18.0.3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"web.myapp.compose.loc", "2.web.myapp.compose.loc"}, domains)
+
+	// docker compose run containers are skipped by default
+	oneoff := genComposeReplica("bb155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "1", "172.18.0.9")
+	oneoff.Config.Labels["com.docker.compose.oneoff"] = "True"
+	domains, err = resolver.resolve(oneoff)
+	assert.Nil(t, err)
+	assert.Empty(t, domains)
+
+	resolver.includeOneoff = true
+	domains, err = resolver.resolve(oneoff)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"web.myapp.compose.loc", "1.web.myapp.compose.loc"}, domains)
+}
+
+func TestComposeReplicaAggregateName(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	compose_domain compose.loc
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+
+	assert.Nil(t, dd.updateContainerInfo(genComposeReplica("aa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "1", "172.18.0.2")))
+	assert.Nil(t, dd.updateContainerInfo(genComposeReplica("bb155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "2", "172.18.0.3")))
+
+	_ = ipOk(t, dd, "1.web.myapp.compose.loc.", net.ParseIP("172.18.0.2"))
+	_ = ipOk(t, dd, "2.web.myapp.compose.loc.", net.ParseIP("172.18.0.3"))
+
+	var addresses []string
+	for _, ip := range dd.addressesByDomain("web.myapp.compose.loc.", false) {
+		addresses = append(addresses, ip.String())
+	}
+	assert.ElementsMatch(t, []string{"172.18.0.2", "172.18.0.3"}, addresses)
+	assert.Empty(t, dd.addressesByDomain("web.myapp.compose.loc.", true))
+
+	// the aggregate name answers with every replica
+	m := new(dns.Msg)
+	m.SetQuestion("web.myapp.compose.loc.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err = dd.ServeDNS(context.Background(), rec, m)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rec.Msg.Answer))
+}
+
+func TestComposeDomainConfigOptions(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	compose_domain compose.loc include_oneoff
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.True(t, dd.resolvers[1].(*ComposeResolver).includeOneoff)
+
+	c = caddy.NewTestController("dns", `docker {
+	compose_domain compose.loc bogus
+}`)
+	_, err = createPlugin(c)
+	assert.NotNil(t, err)
+}
+
+func TestLabelResolverMultipleNames(t *testing.T) {
+	resolver := LabelResolver{hostLabel: "coredns.dockerdiscovery.host"}
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected []string
+	}{
+		{
+			name:     "single name",
+			labels:   map[string]string{"coredns.dockerdiscovery.host": "nginx.loc"},
+			expected: []string{"nginx.loc"},
+		},
+		{
+			name:     "comma and space separated",
+			labels:   map[string]string{"coredns.dockerdiscovery.host": "a.loc, b.loc c.loc,,d.loc."},
+			expected: []string{"a.loc", "b.loc", "c.loc", "d.loc"},
+		},
+		{
+			name: "indexed labels in numeric order",
+			labels: map[string]string{
+				"coredns.dockerdiscovery.host":    "a.loc",
+				"coredns.dockerdiscovery.host.10": "c.loc",
+				"coredns.dockerdiscovery.host.2":  "b.loc",
+				"coredns.dockerdiscovery.host.x":  "ignored.loc",
+			},
+			expected: []string{"a.loc", "b.loc", "c.loc"},
+		},
+		{
+			name: "indexed labels only",
+			labels: map[string]string{
+				"coredns.dockerdiscovery.host.1": "a.loc",
+			},
+			expected: []string{"a.loc"},
+		},
+		{
+			name: "duplicates and invalid names dropped",
+			labels: map[string]string{
+				"coredns.dockerdiscovery.host":   "a.loc,-bad.loc,a.loc",
+				"coredns.dockerdiscovery.host.1": "a.loc b..loc",
+			},
+			expected: []string{"a.loc"},
+		},
+		{
+			name:     "empty value",
+			labels:   map[string]string{"coredns.dockerdiscovery.host": ""},
+			expected: nil,
+		},
+		{
+			name:     "hostname label is not an indexed host label",
+			labels:   map[string]string{"coredns.dockerdiscovery.hostname": "ldap.loc"},
+			expected: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			container := &dockerapi.Container{
+				ID:     "fa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7",
+				Config: &dockerapi.Config{Labels: tc.labels},
+			}
+			domains, err := resolver.resolve(container)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, domains)
+		})
+	}
+}
+
+func TestCnameTargetMultipleHostnames(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	cname_target infra-1.homelab.local
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+
+	container := &dockerapi.Container{
+		ID:   "cd255d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7",
+		Name: "openldap",
+		Config: &dockerapi.Config{
+			Labels: map[string]string{
+				"coredns.dockerdiscovery.hostname":   "ldap.homelab.local,ldaps.homelab.local",
+				"coredns.dockerdiscovery.hostname.1": "directory.homelab.local",
+			},
+		},
+		HostConfig:      &dockerapi.HostConfig{},
+		NetworkSettings: &dockerapi.NetworkSettings{},
+	}
+	assert.Nil(t, dd.updateContainerInfo(container))
+
+	for _, name := range []string{"ldap.homelab.local.", "ldaps.homelab.local.", "directory.homelab.local."} {
+		result, err := dd.containerInfoByDomain(name)
+		assert.Nil(t, err)
+		assert.NotNil(t, result, name)
+		assert.True(t, result.isCNAME)
+	}
+}
+
+func TestLabelPrefix(t *testing.T) {
+	networkName := "guest"
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	cname_target infra-1.homelab.local
+	label_prefix coredns.guest
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "coredns.guest.host", dd.resolvers[0].(*LabelResolver).hostLabel)
+	assert.Equal(t, "coredns.guest.hostname", dd.cnameResolvers[0].(*LabelResolver).hostLabel)
+	assert.Equal(t, "coredns.guest.enable", dd.filter.enableLabel)
+
+	container := &dockerapi.Container{
+		ID:   "fa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7",
+		Name: "wiki",
+		Config: &dockerapi.Config{
+			Labels: map[string]string{
+				"coredns.dockerdiscovery.host":     "internal.loc",
+				"coredns.dockerdiscovery.hostname": "internal-cname.loc",
+				"coredns.guest.host":               "guest.loc",
+				"coredns.guest.hostname":           "guest-cname.loc",
+				"coredns.guest.address":            "10.0.0.7",
+				"coredns.dockerdiscovery.address":  "10.0.0.8",
+			},
+		},
+		HostConfig: &dockerapi.HostConfig{
+			NetworkMode: networkName,
+		},
+		NetworkSettings: &dockerapi.NetworkSettings{
+			Networks: map[string]dockerapi.ContainerNetwork{
+				networkName: {IPAddress: "172.18.0.2"},
+			},
+		},
+	}
+	assert.Nil(t, dd.updateContainerInfo(container))
+
+	_ = ipOk(t, dd, "guest.loc.", net.ParseIP("10.0.0.7"))
+	ipNotOk(t, dd, "internal.loc.")
+	ipNotOk(t, dd, "internal-cname.loc.")
+	result, err := dd.containerInfoByDomain("guest-cname.loc.")
+	assert.Nil(t, err)
+	assert.NotNil(t, result)
+	assert.True(t, result.isCNAME)
+
+	// The enable label follows the prefix too
+	container.Config.Labels["coredns.dockerdiscovery.enable"] = "false"
+	assert.Nil(t, dd.updateContainerInfo(container))
+	_ = ipOk(t, dd, "guest.loc.", net.ParseIP("10.0.0.7"))
+	container.Config.Labels["coredns.guest.enable"] = "false"
+	assert.Nil(t, dd.updateContainerInfo(container))
+	ipNotOk(t, dd, "guest.loc.")
+}
+
+func TestLabelPrefixExplicitLabel(t *testing.T) {
+	// An explicit label directive is not namespaced
+	c := caddy.NewTestController("dns", `docker {
+	label_prefix coredns.guest
+	label custom.host
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "custom.host", dd.resolvers[0].(*LabelResolver).hostLabel)
+	assert.Equal(t, "coredns.guest.network", dd.label("network"))
+}
+
+func TestTraefikRouters(t *testing.T) {
+	routers := traefikRouters(map[string]string{
+		"traefik.udp.routers.dns.entrypoints":                "dns-udp",
+		"traefik.tcp.routers.pg.rule":                        "HostSNI(`db.example.com`)",
+		"traefik.tcp.routers.pg.entrypoints":                 "postgres",
+		"traefik.http.routers.web.rule":                      "Host(`web.example.com`)",
+		"traefik.http.routers.web.entrypoints":               "web,websecure",
+		"traefik.http.routers.web.tls":                       "true",
+		"traefik.http.services.web.loadbalancer.server.port": "80",
+	})
+	assert.Equal(t, 3, len(routers))
+	assert.Equal(t, traefikRouter{protocol: "http", name: "web", rule: "Host(`web.example.com`)", entrypoints: []string{"web", "websecure"}}, *routers[0])
+	assert.Equal(t, traefikRouter{protocol: "tcp", name: "pg", rule: "HostSNI(`db.example.com`)", entrypoints: []string{"postgres"}}, *routers[1])
+	assert.Equal(t, traefikRouter{protocol: "udp", name: "dns", entrypoints: []string{"dns-udp"}}, *routers[2])
+}
+
+func TestTraefikTCPTarget(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	traefik_cname traefik.homelab.net
+	traefik_tcp_a 10.0.0.3
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.3", dd.traefikTCPTarget.a.String())
+
+	container := &dockerapi.Container{
+		ID:   "ab155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7",
+		Name: "db",
+		Config: &dockerapi.Config{
+			Labels: map[string]string{
+				"traefik.http.routers.admin.rule": "Host(`pgadmin.homelab.net`)",
+				"traefik.tcp.routers.pg.rule":     "HostSNI(`db.homelab.net`)",
+				"traefik.tcp.routers.all.rule":    "HostSNI(`*`)",
+			},
+		},
+		HostConfig:      &dockerapi.HostConfig{},
+		NetworkSettings: &dockerapi.NetworkSettings{},
+	}
+	assert.Nil(t, dd.updateContainerInfo(container))
+
+	result, err := dd.containerInfoByDomain("pgadmin.homelab.net.")
+	assert.Nil(t, err)
+	assert.NotNil(t, result)
+	assert.Equal(t, recordTarget{cname: "traefik.homelab.net"}, result.target)
+
+	result, err = dd.containerInfoByDomain("db.homelab.net.")
+	assert.Nil(t, err)
+	assert.NotNil(t, result)
+	assert.Equal(t, "", result.target.cname)
+	assert.Equal(t, "10.0.0.3", result.target.a.String())
+
+	// TCP hosts answer with the TCP target
+	m := new(dns.Msg)
+	m.SetQuestion("db.homelab.net.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	_, err = dd.ServeDNS(context.Background(), rec, m)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(rec.Msg.Answer))
+	assert.Equal(t, "10.0.0.3", rec.Msg.Answer[0].(*dns.A).A.String())
+}
+
+func TestTraefikTCPTargetConfig(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	traefik_tcp_cname tcp.homelab.net
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "tcp.homelab.net", dd.traefikTCPTarget.cname)
+	assert.NotNil(t, dd.traefikResolver)
+
+	c = caddy.NewTestController("dns", `docker {
+	traefik_tcp_cname tcp.homelab.net
+	traefik_tcp_a 10.0.0.3
+}`)
+	_, err = createPlugin(c)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "mutually exclusive")
+
+	c = caddy.NewTestController("dns", `docker {
+	traefik_tcp_a not-an-ip
+}`)
+	_, err = createPlugin(c)
+	assert.NotNil(t, err)
+
+	c = caddy.NewTestController("dns", `docker {
+	label_prefix coredns.guest
+	traefik_cname traefik.homelab.net
+}`)
+	dd, err = createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "coredns.guest.traefik_udp_host", dd.traefikResolver.udpHostLabel)
+}
+
+func TestTraefikTCPTargetWithCloudflareTarget(t *testing.T) {
+	// cf_target remains the target of HTTP routers
+	c := caddy.NewTestController("dns", `docker {
+	traefik_tcp_cname tcp.homelab.net
+	cf_token my-api-token
+	cf_target traefik.homelab.net
+	cf_zone homelab.net zone123
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "traefik.homelab.net", dd.traefikCNAME)
+	assert.Equal(t, "tcp.homelab.net", dd.traefikTCPTarget.cname)
+
+	web := genRunningContainer("fa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "web", "172.17.0.2")
+	web.Config.Labels["traefik.http.routers.web.rule"] = "Host(`web.homelab.net`)"
+	assert.Nil(t, dd.updateContainerInfo(web))
+	result, _ := dd.containerInfoByDomain("web.homelab.net.")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "traefik.homelab.net", result.target.cname)
+	}
+
+	// and the file and API providers need it
+	for _, provider := range []string{"traefik_file " + t.TempDir(), "traefik_api http://traefik:8080"} {
+		c = caddy.NewTestController("dns", "docker {\n\ttraefik_tcp_cname tcp.homelab.net\n\t"+provider+"\n}")
+		_, err = createPlugin(c)
+		assert.NotNil(t, err, provider)
+	}
+}
+
+func genTraefikContainer(name string, labels map[string]string) *dockerapi.Container {
+	return &dockerapi.Container{
+		ID:   "fa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7",
+		Name: "/" + name,
+		Config: &dockerapi.Config{
+			Labels: labels,
+		},
+	}
+}
+
+func TestTraefikEnableLabel(t *testing.T) {
+	resolver := NewTraefikLabelResolver()
+	rule := "Host(`app.example.com`)"
+
+	domains, _ := resolver.resolve(genTraefikContainer("app", map[string]string{"traefik.http.routers.app.rule": rule}))
+	assert.Equal(t, []string{"app.example.com"}, domains)
+	domains, _ = resolver.resolve(genTraefikContainer("app", map[string]string{"traefik.http.routers.app.rule": rule, "traefik.enable": "false"}))
+	assert.Empty(t, domains)
+
+	resolver.exposedByDefault = false
+	domains, _ = resolver.resolve(genTraefikContainer("app", map[string]string{"traefik.http.routers.app.rule": rule}))
+	assert.Empty(t, domains)
+	domains, _ = resolver.resolve(genTraefikContainer("app", map[string]string{"traefik.http.routers.app.rule": rule, "traefik.enable": "true"}))
+	assert.Equal(t, []string{"app.example.com"}, domains)
+}
+
+func TestTraefikDefaultRule(t *testing.T) {
+	resolver := NewTraefikLabelResolver()
+	tmpl, err := newContainerTemplate("traefik_default_rule", "Host(`{{ normalize .Name }}.example.com`)")
+	assert.Nil(t, err)
+	resolver.defaultRule = tmpl
+
+	// No routers: implicit router with the default rule
+	domains, _ := resolver.resolve(genTraefikContainer("My_App", map[string]string{"traefik.enable": "true"}))
+	assert.Equal(t, []string{"my-app.example.com"}, domains)
+
+	// Compose containers are named <service>-<project>
+	domains, _ = resolver.resolve(genTraefikContainer("myapp-web-1", map[string]string{
+		"com.docker.compose.project": "myapp",
+		"com.docker.compose.service": "web",
+	}))
+	assert.Equal(t, []string{"web-myapp.example.com"}, domains)
+
+	// .Name is normalized like in Traefik, without normalize
+	tmpl, err = newContainerTemplate("traefik_default_rule", "Host(`{{ .Name }}.example.com`)")
+	assert.Nil(t, err)
+	resolver.defaultRule = tmpl
+	domains, _ = resolver.resolve(genTraefikContainer("myapp-my_svc-1", map[string]string{
+		"com.docker.compose.project": "proj",
+		"com.docker.compose.service": "my_svc",
+	}))
+	assert.Equal(t, []string{"my-svc-proj.example.com"}, domains)
+	domains, _ = resolver.resolve(genTraefikContainer("My.App", map[string]string{"traefik.enable": "true"}))
+	assert.Equal(t, []string{"my-app.example.com"}, domains)
+
+	// HTTP routers without rule get the default rule, others keep theirs
+	domains, _ = resolver.resolve(genTraefikContainer("app", map[string]string{
+		"traefik.http.routers.web.entrypoints": "web",
+		"traefik.http.routers.api.rule":        "Host(`api.example.com`)",
+	}))
+	assert.ElementsMatch(t, []string{"app.example.com", "api.example.com"}, domains)
+
+	// Disabled containers get nothing
+	domains, _ = resolver.resolve(genTraefikContainer("app", map[string]string{"traefik.enable": "false"}))
+	assert.Empty(t, domains)
+
+	// Without a default rule, rule-less routers publish nothing
+	resolver.defaultRule = nil
+	domains, _ = resolver.resolve(genTraefikContainer("app", map[string]string{"traefik.http.routers.web.entrypoints": "web"}))
+	assert.Empty(t, domains)
+}
+
+func TestTraefikEntrypointFilter(t *testing.T) {
+	resolver := NewTraefikLabelResolver()
+	resolver.entrypoints["websecure"] = true
+
+	labels := map[string]string{
+		"traefik.http.routers.public.rule":          "Host(`public.example.com`)",
+		"traefik.http.routers.public.entrypoints":   "web,websecure",
+		"traefik.http.routers.internal.rule":        "Host(`internal.example.com`)",
+		"traefik.http.routers.internal.entrypoints": "web-internal",
+		"traefik.http.routers.all.rule":             "Host(`all.example.com`)",
+	}
+	domains, _ := resolver.resolve(genTraefikContainer("app", labels))
+	assert.ElementsMatch(t, []string{"public.example.com", "all.example.com"}, domains)
+}
+
+func TestTraefikProviderOptionsConfig(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	traefik_exposed_by_default false
+	traefik_default_rule Host(`+"`{{ normalize .Name }}.example.com`"+`)
+	traefik_entrypoints web,websecure web-internal
+	traefik_cname traefik.example.com
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.NotNil(t, dd.traefikResolver)
+	assert.False(t, dd.traefikResolver.exposedByDefault)
+	assert.NotNil(t, dd.traefikResolver.defaultRule)
+	assert.Equal(t, map[string]bool{"web": true, "websecure": true, "web-internal": true}, dd.traefikResolver.entrypoints)
+
+	// Options alone don't enable the traefik resolver
+	c = caddy.NewTestController("dns", `docker {
+	traefik_exposed_by_default false
+}`)
+	dd, err = createPlugin(c)
+	assert.Nil(t, err)
+	assert.Nil(t, dd.traefikResolver)
+
+	for _, block := range []string{
+		"traefik_exposed_by_default maybe",
+		"traefik_default_rule Host(`{{ .Name`)",
+		"traefik_default_rule",
+		"traefik_entrypoints",
+	} {
+		c = caddy.NewTestController("dns", "docker {\n"+block+"\n}")
+		_, err = createPlugin(c)
+		assert.NotNil(t, err, block)
+	}
+}
+
+func TestTraefikEntrypointTargets(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	traefik_cname traefik.lan
+	traefik_tcp_cname tcp.lan
+	traefik_entrypoint websecure-public public.lan
+	traefik_entrypoint web-internal 10.0.0.2
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, recordTarget{cname: "public.lan"}, dd.traefikEntrypointTargets["websecure-public"])
+	assert.Equal(t, "10.0.0.2", dd.traefikEntrypointTargets["web-internal"].a.String())
+
+	container := &dockerapi.Container{
+		ID:   "ab155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7",
+		Name: "app",
+		Config: &dockerapi.Config{
+			Labels: map[string]string{
+				"traefik.http.routers.public.rule":          "Host(`app.example.com`)",
+				"traefik.http.routers.public.entrypoints":   "web,websecure-public",
+				"traefik.http.routers.internal.rule":        "Host(`app.lan`)",
+				"traefik.http.routers.internal.entrypoints": "web-internal",
+				"traefik.http.routers.other.rule":           "Host(`other.lan`)",
+				"traefik.http.routers.other.entrypoints":    "unmapped",
+				"traefik.tcp.routers.db.rule":               "HostSNI(`db.lan`)",
+				"traefik.tcp.routers.db.entrypoints":        "postgres",
+			},
+		},
+		HostConfig:      &dockerapi.HostConfig{},
+		NetworkSettings: &dockerapi.NetworkSettings{},
+	}
+	assert.Nil(t, dd.updateContainerInfo(container))
+
+	expected := map[string]recordTarget{
+		"app.example.com.": {cname: "public.lan"},
+		"app.lan.":         {a: net.ParseIP("10.0.0.2")},
+		"other.lan.":       {cname: "traefik.lan"},
+		"db.lan.":          {cname: "tcp.lan"},
+	}
+	for name, target := range expected {
+		result, err := dd.containerInfoByDomain(name)
+		assert.Nil(t, err)
+		assert.NotNil(t, result, name)
+		assert.Equal(t, target.cname, result.target.cname, name)
+		assert.Equal(t, target.a.String(), result.target.a.String(), name)
+	}
+
+	c = caddy.NewTestController("dns", `docker {
+	traefik_entrypoint websecure
+}`)
+	_, err = createPlugin(c)
+	assert.NotNil(t, err)
+}
+
+func TestTraefikEntrypointTargetsOnly(t *testing.T) {
+	container := &dockerapi.Container{
+		ID:   "ab155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7",
+		Name: "app",
+		Config: &dockerapi.Config{
+			Labels: map[string]string{
+				"traefik.http.routers.public.rule":        "Host(`app.example.com`)",
+				"traefik.http.routers.public.entrypoints": "websecure-public",
+				"traefik.http.routers.other.rule":         "Host(`other.lan`)",
+				"traefik.http.routers.other.entrypoints":  "unmapped",
+			},
+		},
+		HostConfig:      &dockerapi.HostConfig{},
+		NetworkSettings: &dockerapi.NetworkSettings{},
+	}
+
+	// Without a default target, routers on unmapped entrypoints aren't published
+	c := caddy.NewTestController("dns", `docker {
+	traefik_entrypoint websecure-public public.lan
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Nil(t, dd.updateContainerInfo(container))
+	result, _ := dd.containerInfoByDomain("app.example.com.")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "public.lan", result.target.cname)
+	}
+	result, _ = dd.containerInfoByDomain("other.lan.")
+	assert.Nil(t, result)
+
+	// cf_target remains the default target
+	c = caddy.NewTestController("dns", `docker {
+	traefik_entrypoint websecure-public public.lan
+	cf_token my-api-token
+	cf_target traefik.homelab.net
+	cf_zone homelab.net zone123
+}`)
+	dd, err = createPlugin(c)
+	assert.Nil(t, err)
+	assert.Nil(t, dd.updateContainerInfo(container))
+	result, _ = dd.containerInfoByDomain("other.lan.")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "traefik.homelab.net", result.target.cname)
+	}
+}
+
+func TestTraefikTLSDomains(t *testing.T) {
+	labels := map[string]string{
+		"traefik.http.routers.app.rule":                 "Host(`app.example.com`)",
+		"traefik.http.routers.app.tls.domains[1].main":  "other.org",
+		"traefik.http.routers.app.tls.domains[0].sans":  "*.Example.com, www.example.com",
+		"traefik.http.routers.app.tls.domains[0].main":  "example.com",
+		"traefik.http.routers.app.tls.domains[2].main":  "bad!name",
+		"traefik.tcp.routers.db.rule":                   "HostSNI(`db.example.com`)",
+		"traefik.tcp.routers.db.tls.domains[0].main":    "*.db.example.com",
+		"traefik.http.routers.app.tls.domains.bad.main": "ignored.example.com",
+	}
+	routers := traefikRouters(labels)
+	assert.Equal(t, []string{"example.com", "*.Example.com", "www.example.com", "other.org", "bad!name"}, routers[0].tlsDomains)
+
+	// Off by default
+	resolver := NewTraefikLabelResolver()
+	domains, _ := resolver.resolve(genTraefikContainer("app", labels))
+	assert.Equal(t, []string{"app.example.com", "db.example.com"}, domains)
+
+	resolver.tlsDomains = true
+	domains, _ = resolver.resolve(genTraefikContainer("app", labels))
+	assert.Equal(t, []string{
+		"app.example.com", "example.com", "*.example.com", "www.example.com", "other.org",
+		"db.example.com", "*.db.example.com",
+	}, domains)
+}
+
+func TestWildcardDomainLookup(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	traefik_cname traefik.lan
+	traefik_tls_domains
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.True(t, dd.traefikResolver.tlsDomains)
+
+	app := genTraefikContainer("app", map[string]string{
+		"traefik.http.routers.app.rule":                "Host(`app.example.com`)",
+		"traefik.http.routers.app.tls.domains[0].main": "*.example.com",
+	})
+	app.HostConfig = &dockerapi.HostConfig{}
+	app.NetworkSettings = &dockerapi.NetworkSettings{}
+	assert.Nil(t, dd.updateContainerInfo(app))
+
+	for _, name := range []string{"app.example.com.", "foo.example.com.", "a.b.example.com."} {
+		result, err := dd.containerInfoByDomain(name)
+		assert.Nil(t, err)
+		assert.NotNil(t, result, name)
+		assert.True(t, result.isCNAME, name)
+		assert.Equal(t, "traefik.lan", result.target.cname, name)
+	}
+	// The wildcard doesn't cover the apex
+	result, err := dd.containerInfoByDomain("example.com.")
+	assert.Nil(t, err)
+	assert.Nil(t, result)
+
+	assert.Equal(t, domainMatchExact, matchDomain("*.example.com", "*.example.com."))
+	assert.Equal(t, domainMatchWildcard, matchDomain("*.example.com", "x.example.com."))
+	assert.Equal(t, domainMatchNone, matchDomain("*.example.com", "xexample.com."))
+	assert.Equal(t, domainMatchNone, matchDomain("app.example.com", "x.app.example.com."))
+}
+
+func TestCaddyLabelResolver(t *testing.T) {
+	resolver := CaddyLabelResolver{}
+	domains, err := resolver.resolve(genTraefikContainer("app", map[string]string{
+		"caddy":                 "App.example.com, https://www.example.com:8443",
+		"caddy.reverse_proxy":   "{{upstreams 8080}}",
+		"caddy_10":              "ten.example.com",
+		"caddy_2":               "http://two.example.com/path two.example.com",
+		"caddy_1":               ":80",
+		"caddy_3":               "(snippet)",
+		"caddy_4":               "{$DOMAIN} 192.168.1.10 *.apps.example.com",
+		"caddy_2.reverse_proxy": "{{upstreams 80}}",
+		"caddyfile":             "other.example.com",
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app.example.com", "www.example.com", "two.example.com", "*.apps.example.com", "ten.example.com"}, domains)
+}
+
+func TestCaddySiteHost(t *testing.T) {
+	assert.Equal(t, "app.example.com", caddySiteHost("app.example.com"))
+	assert.Equal(t, "app.example.com", caddySiteHost("https://App.example.com:443/api"))
+	assert.Equal(t, "app.example.com", caddySiteHost("app.example.com:8080"))
+	assert.Equal(t, "", caddySiteHost(":80"))
+	assert.Equal(t, "::1", caddySiteHost("[::1]:443"))
+}
+
+func TestCaddyConfig(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	traefik_cname traefik.lan
+	caddy_cname caddy.lan
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(dd.proxyResolvers))
+
+	container := genTraefikContainer("app", map[string]string{
+		"caddy":                         "app.example.com",
+		"traefik.http.routers.web.rule": "Host(`web.example.com`)",
+	})
+	container.HostConfig = &dockerapi.HostConfig{}
+	container.NetworkSettings = &dockerapi.NetworkSettings{}
+	assert.Nil(t, dd.updateContainerInfo(container))
+
+	result, _ := dd.containerInfoByDomain("app.example.com.")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "caddy.lan", result.target.cname)
+	}
+	result, _ = dd.containerInfoByDomain("web.example.com.")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "traefik.lan", result.target.cname)
+	}
+
+	c = caddy.NewTestController("dns", `docker {
+	caddy_a 10.0.0.3
+}`)
+	dd, err = createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.3", dd.proxyResolvers[0].target.a.String())
+
+	for _, block := range []string{
+		"caddy_cname",
+		"caddy_a not-an-ip",
+		"caddy_cname caddy.lan\ncaddy_a 10.0.0.3",
+		"caddy_a 10.0.0.3\ncaddy_cname caddy.lan",
+	} {
+		c = caddy.NewTestController("dns", "docker {\n"+block+"\n}")
+		_, err = createPlugin(c)
+		assert.NotNil(t, err, block)
+	}
+}
+
+func TestNginxProxyResolver(t *testing.T) {
+	container := genTraefikContainer("app", nil)
+	container.Config.Env = []string{
+		"PATH=/usr/bin",
+		"VIRTUAL_HOST=App.example.com, www.example.com,*.apps.example.com,example.*,~^api\\..*$",
+		"LETSENCRYPT_HOST=app.example.com,cert.example.com",
+		"VIRTUAL_PORT=8080",
+	}
+	domains, err := NginxProxyResolver{}.resolve(container)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app.example.com", "www.example.com", "*.apps.example.com", "cert.example.com"}, domains)
+
+	domains, err = NginxProxyResolver{}.resolve(genTraefikContainer("plain", nil))
+	assert.Nil(t, err)
+	assert.Empty(t, domains)
+}
+
+func TestNginxProxyConfig(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	caddy_cname caddy.lan
+	nginx_proxy_a 10.0.0.4
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(dd.proxyResolvers))
+
+	container := genTraefikContainer("app", map[string]string{"caddy": "caddy.example.com"})
+	container.Config.Env = []string{"VIRTUAL_HOST=nginx.example.com"}
+	container.HostConfig = &dockerapi.HostConfig{}
+	container.NetworkSettings = &dockerapi.NetworkSettings{}
+	assert.Nil(t, dd.updateContainerInfo(container))
+
+	result, _ := dd.containerInfoByDomain("nginx.example.com.")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "10.0.0.4", result.target.a.String())
+	}
+	result, _ = dd.containerInfoByDomain("caddy.example.com.")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "caddy.lan", result.target.cname)
+	}
+
+	for _, block := range []string{
+		"nginx_proxy_cname",
+		"nginx_proxy_a proxy.lan",
+		"nginx_proxy_a 10.0.0.4\nnginx_proxy_cname proxy.lan",
+	} {
+		c = caddy.NewTestController("dns", "docker {\n"+block+"\n}")
+		_, err = createPlugin(c)
+		assert.NotNil(t, err, block)
+	}
+}
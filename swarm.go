@@ -0,0 +1,196 @@
+package dockerdiscovery
+
+import (
+	"log"
+	"net"
+	"sort"
+
+	"github.com/docker/docker/api/types/swarm"
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+// swarmKeyPrefix prefixes the containerInfoMap keys of Swarm services
+// (swarm:<service ID>) and, for dnsrr services, of their tasks
+// (swarm:<service ID>/<task ID>).
+const swarmKeyPrefix = "swarm:"
+
+// swarmTaskLabel is set on the containers of Swarm tasks. They are published
+// through their service rather than under their task name.
+const swarmTaskLabel = "com.docker.swarm.task.id"
+
+// swarmNetwork is a network a Swarm task is attached to.
+type swarmNetwork struct {
+	name    string
+	ingress bool
+}
+
+// syncSwarm lists the Swarm services and their running tasks and publishes
+// <service>.<swarm domain>, plus the names resolved from the service labels
+// (hosts, Traefik rules, ...). Services in vip mode resolve to their
+// virtual IP, dnsrr services to the IPs of all their running tasks.
+func (dd *DockerDiscovery) syncSwarm() error {
+	dd.swarmMutex.Lock()
+	defer dd.swarmMutex.Unlock()
+
+	services, err := dd.dockerClient.ListServices(dockerapi.ListServicesOptions{})
+	if err != nil {
+		return err
+	}
+	tasks, err := dd.dockerClient.ListTasks(dockerapi.ListTasksOptions{
+		Filters: map[string][]string{"desired-state": {"running"}},
+	})
+	if err != nil {
+		return err
+	}
+
+	networks := make(map[string]swarmNetwork)
+	tasksByService := make(map[string][]swarm.Task)
+	for _, task := range tasks {
+		for _, attachment := range task.NetworksAttachments {
+			networks[attachment.Network.ID] = swarmNetwork{name: attachment.Network.Spec.Name, ingress: attachment.Network.Spec.Ingress}
+		}
+		if task.Status.State == swarm.TaskStateRunning {
+			tasksByService[task.ServiceID] = append(tasksByService[task.ServiceID], task)
+		}
+	}
+
+	entries := make(map[string]*ContainerInfo)
+	for i := range services {
+		service := &services[i]
+		serviceTasks := tasksByService[service.ID]
+		sort.Slice(serviceTasks, func(i, j int) bool { return serviceTasks[i].ID < serviceTasks[j].ID })
+
+		container := swarmServiceContainer(service, serviceTasks, networks)
+		if !dd.filter.allows(container) {
+			log.Printf("[docker] Swarm service %s is excluded by filters", service.Spec.Name)
+			continue
+		}
+		domains, cnameDomains, cnameTargets, _ := dd.resolveDomainsByContainer(container)
+		for _, d := range rewriteNames(dd.nameRewrites, []string{service.Spec.Name + "." + dd.swarmDomain}) {
+			if !containsString(domains, d) {
+				domains = append(domains, d)
+			}
+		}
+
+		network := container.Config.Labels[dd.label("network")]
+		newEntry := func(address net.IP) *ContainerInfo {
+			entry := &ContainerInfo{container: container, address: address, cnameDomains: cnameDomains, cnameTargets: cnameTargets}
+			if address != nil {
+				entry.domains = domains
+			}
+			return entry
+		}
+
+		if service.Endpoint.Spec.Mode == swarm.ResolutionModeDNSRR {
+			for _, task := range serviceTasks {
+				entries[swarmKeyPrefix+service.ID+"/"+task.ID] = newEntry(swarmTaskAddress(task, network))
+			}
+			if len(serviceTasks) == 0 {
+				entries[swarmKeyPrefix+service.ID] = newEntry(nil)
+			}
+		} else {
+			entries[swarmKeyPrefix+service.ID] = newEntry(swarmServiceVIP(service, networks, network))
+		}
+	}
+
+	dd.syncExternalEntries(swarmKeyPrefix, entries)
+	return nil
+}
+
+// swarmServiceContainer describes a service as a container, so resolvers
+// and filters apply to services as they do to containers: its labels are
+// the service labels, and its networks those its tasks are attached to.
+func swarmServiceContainer(service *swarm.Service, tasks []swarm.Task, networks map[string]swarmNetwork) *dockerapi.Container {
+	container := &dockerapi.Container{
+		ID:   service.ID,
+		Name: service.Spec.Name,
+		Config: &dockerapi.Config{
+			Labels: service.Spec.Labels,
+		},
+		HostConfig: &dockerapi.HostConfig{},
+		NetworkSettings: &dockerapi.NetworkSettings{
+			Networks: make(map[string]dockerapi.ContainerNetwork),
+		},
+	}
+	if container.Config.Labels == nil {
+		container.Config.Labels = make(map[string]string)
+	}
+	if spec := service.Spec.TaskTemplate.ContainerSpec; spec != nil {
+		container.Config.Image = spec.Image
+	}
+	for _, vip := range service.Endpoint.VirtualIPs {
+		if network, ok := networks[vip.NetworkID]; ok && !network.ingress {
+			container.NetworkSettings.Networks[network.name] = dockerapi.ContainerNetwork{}
+		}
+	}
+	for _, task := range tasks {
+		for _, attachment := range task.NetworksAttachments {
+			if !attachment.Network.Spec.Ingress {
+				container.NetworkSettings.Networks[attachment.Network.Spec.Name] = dockerapi.ContainerNetwork{}
+			}
+		}
+	}
+	return container
+}
+
+// swarmServiceVIP returns the virtual IP of the service on the given
+// network, or, without one, on the first network other than the ingress
+// network (which only serves the routing mesh).
+func swarmServiceVIP(service *swarm.Service, networks map[string]swarmNetwork, networkName string) net.IP {
+	for _, vip := range service.Endpoint.VirtualIPs {
+		network, ok := networks[vip.NetworkID]
+		if network.ingress || (networkName != "" && (!ok || network.name != networkName)) {
+			continue
+		}
+		if ip := parseCIDRAddress(vip.Addr); ip != nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+// swarmTaskAddress returns the address of the task on the given network,
+// or, without one, on the first network other than the ingress network.
+func swarmTaskAddress(task swarm.Task, networkName string) net.IP {
+	for _, attachment := range task.NetworksAttachments {
+		if attachment.Network.Spec.Ingress || (networkName != "" && attachment.Network.Spec.Name != networkName) {
+			continue
+		}
+		for _, address := range attachment.Addresses {
+			if ip := parseCIDRAddress(address); ip != nil && ip.To4() != nil {
+				return ip
+			}
+		}
+	}
+	return nil
+}
+
+// parseCIDRAddress parses addresses like 10.0.1.5/24, as Swarm reports them.
+func parseCIDRAddress(address string) net.IP {
+	if ip, _, err := net.ParseCIDR(address); err == nil {
+		return ip
+	}
+	return net.ParseIP(address)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// isSwarmEvent reports whether the event may change the Swarm services or
+// their tasks.
+func isSwarmEvent(msg *dockerapi.APIEvents) bool {
+	switch msg.Type {
+	case "service", "node":
+		return true
+	case "container":
+		// Tasks starting or stopping on this node
+		return msg.Actor.Attributes["com.docker.swarm.service.id"] != "" && (msg.Action == "start" || msg.Action == "die")
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package dockerdiscovery
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/docker/docker/api/types/swarm"
+	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	swarmIngress = swarm.Network{ID: "net-ingress", Spec: swarm.NetworkSpec{Annotations: swarm.Annotations{Name: "ingress"}, Ingress: true}}
+	swarmProxy   = swarm.Network{ID: "net-proxy", Spec: swarm.NetworkSpec{Annotations: swarm.Annotations{Name: "proxy"}}}
+	swarmBackend = swarm.Network{ID: "net-backend", Spec: swarm.NetworkSpec{Annotations: swarm.Annotations{Name: "backend"}}}
+)
+
+func genSwarmService(id string, name string, mode swarm.ResolutionMode, labels map[string]string, vips ...swarm.EndpointVirtualIP) swarm.Service {
+	service := swarm.Service{ID: id}
+	service.Spec.Name = name
+	service.Spec.Labels = labels
+	service.Spec.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{Image: "nginx:1.25"}
+	service.Endpoint.Spec.Mode = mode
+	service.Endpoint.VirtualIPs = vips
+	return service
+}
+
+func genSwarmTask(id string, serviceID string, state swarm.TaskState, attachments ...swarm.NetworkAttachment) swarm.Task {
+	task := swarm.Task{ID: id, ServiceID: serviceID, NetworksAttachments: attachments}
+	task.Status.State = state
+	return task
+}
+
+func newSwarmTestPlugin(t *testing.T, config string) (*DockerDiscovery, *fakeDockerAPI) {
+	fake := newFakeDockerAPI()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	c := caddy.NewTestController("dns", config)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	dd.dockerClient, err = dockerapi.NewClient(server.URL)
+	assert.Nil(t, err)
+	return dd, fake
+}
+
+func TestSyncSwarm(t *testing.T) {
+	dd, fake := newSwarmTestPlugin(t, `docker unix:///home/user/docker.sock {
+	swarm swarm.lan
+	traefik_cname traefik.lan
+}`)
+
+	web := genSwarmService("svc-web", "web", swarm.ResolutionModeVIP,
+		map[string]string{"traefik.http.routers.web.rule": "Host(`web.example.com`)"},
+		swarm.EndpointVirtualIP{NetworkID: "net-ingress", Addr: "10.0.0.5/24"},
+		swarm.EndpointVirtualIP{NetworkID: "net-proxy", Addr: "10.0.1.5/24"},
+	)
+	db := genSwarmService("svc-db", "db", swarm.ResolutionModeDNSRR,
+		map[string]string{"coredns.dockerdiscovery.network": "backend", "coredns.dockerdiscovery.host": "postgres.lan"})
+	fake.setServices([]swarm.Service{web, db}, []swarm.Task{
+		genSwarmTask("task-web-1", "svc-web", swarm.TaskStateRunning,
+			swarm.NetworkAttachment{Network: swarmIngress, Addresses: []string{"10.0.0.6/24"}},
+			swarm.NetworkAttachment{Network: swarmProxy, Addresses: []string{"10.0.1.6/24"}}),
+		genSwarmTask("task-db-1", "svc-db", swarm.TaskStateRunning,
+			swarm.NetworkAttachment{Network: swarmProxy, Addresses: []string{"10.0.1.10/24"}},
+			swarm.NetworkAttachment{Network: swarmBackend, Addresses: []string{"10.0.2.10/24"}}),
+		genSwarmTask("task-db-2", "svc-db", swarm.TaskStateRunning,
+			swarm.NetworkAttachment{Network: swarmBackend, Addresses: []string{"10.0.2.11/24"}}),
+		genSwarmTask("task-db-3", "svc-db", swarm.TaskStateStarting,
+			swarm.NetworkAttachment{Network: swarmBackend, Addresses: []string{"10.0.2.12/24"}}),
+	})
+	assert.Nil(t, dd.syncSwarm())
+
+	// vip mode: the virtual IP outside of the ingress network
+	assert.Equal(t, "10.0.1.5", dd.addressesByDomain("web.swarm.lan.", false)[0].String())
+	result, _ := dd.containerInfoByDomain("web.example.com.")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "traefik.lan", result.target.cname)
+	}
+
+	// dnsrr mode: every running task, on the labelled network
+	for _, name := range []string{"db.swarm.lan.", "postgres.lan."} {
+		var addresses []string
+		for _, ip := range dd.addressesByDomain(name, false) {
+			addresses = append(addresses, ip.String())
+		}
+		assert.ElementsMatch(t, []string{"10.0.2.10", "10.0.2.11"}, addresses, name)
+	}
+
+	// Removed services lose their records
+	fake.setServices([]swarm.Service{web}, nil)
+	assert.Nil(t, dd.syncSwarm())
+	assert.Empty(t, dd.addressesByDomain("db.swarm.lan.", false))
+	assert.Equal(t, 1, len(dd.containerInfoMap))
+}
+
+func TestSyncSwarmFilters(t *testing.T) {
+	dd, fake := newSwarmTestPlugin(t, `docker unix:///home/user/docker.sock {
+	swarm swarm.lan
+	include_network proxy
+}`)
+	fake.setServices([]swarm.Service{
+		genSwarmService("svc-web", "web", swarm.ResolutionModeVIP, nil, swarm.EndpointVirtualIP{NetworkID: "net-proxy", Addr: "10.0.1.5/24"}),
+		genSwarmService("svc-db", "db", swarm.ResolutionModeVIP, nil, swarm.EndpointVirtualIP{NetworkID: "net-backend", Addr: "10.0.2.5/24"}),
+	}, []swarm.Task{
+		genSwarmTask("task-web-1", "svc-web", swarm.TaskStateRunning, swarm.NetworkAttachment{Network: swarmProxy, Addresses: []string{"10.0.1.6/24"}}),
+		genSwarmTask("task-db-1", "svc-db", swarm.TaskStateRunning, swarm.NetworkAttachment{Network: swarmBackend, Addresses: []string{"10.0.2.6/24"}}),
+	})
+	assert.Nil(t, dd.syncSwarm())
+	assert.NotEmpty(t, dd.addressesByDomain("web.swarm.lan.", false))
+	assert.Empty(t, dd.addressesByDomain("db.swarm.lan.", false))
+}
+
+func TestSwarmTaskContainersSkipped(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	domain docker.loc
+	swarm swarm.lan
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "swarm.lan", dd.swarmDomain)
+
+	container := genFilterContainer("nginx", map[string]string{swarmTaskLabel: "task-web-1"})
+	domains, _, _, _ := dd.resolveDomainsByContainer(container)
+	assert.Empty(t, domains)
+
+	// Without swarm mode they are regular containers
+	dd.swarmDomain = ""
+	domains, _, _, _ = dd.resolveDomainsByContainer(container)
+	assert.Equal(t, []string{"app.docker.loc"}, domains)
+
+	c = caddy.NewTestController("dns", `docker {
+	swarm
+}`)
+	_, err = createPlugin(c)
+	assert.NotNil(t, err)
+}
+
+func TestIsSwarmEvent(t *testing.T) {
+	event := func(eventType string, action string, attributes map[string]string) *dockerapi.APIEvents {
+		return &dockerapi.APIEvents{Type: eventType, Action: action, Actor: dockerapi.APIActor{Attributes: attributes}}
+	}
+	assert.True(t, isSwarmEvent(event("service", "update", nil)))
+	assert.True(t, isSwarmEvent(event("node", "remove", nil)))
+	assert.True(t, isSwarmEvent(event("container", "die", map[string]string{"com.docker.swarm.service.id": "svc-web"})))
+	assert.False(t, isSwarmEvent(event("container", "die", nil)))
+	assert.False(t, isSwarmEvent(event("container", "exec_start", map[string]string{"com.docker.swarm.service.id": "svc-web"})))
+	assert.False(t, isSwarmEvent(event("network", "connect", nil)))
+}
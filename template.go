@@ -0,0 +1,114 @@
+package dockerdiscovery
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+// containerTemplateData is the value name templates are executed against.
+// Field names are part of the Corefile syntax — do not rename them.
+type containerTemplateData struct {
+	Name     string            // container name without the leading slash
+	Hostname string            // container hostname (Config.Hostname)
+	Image    string            // image reference the container was created from
+	Labels   map[string]string // all container labels
+	Networks []string          // names of attached networks, sorted
+	Compose  composeTemplateData
+}
+
+// composeTemplateData exposes the docker compose labels of a container.
+// All fields are empty for containers not managed by compose.
+type composeTemplateData struct {
+	Project string // com.docker.compose.project
+	Service string // com.docker.compose.service
+	Number  string // com.docker.compose.container-number
+}
+
+// invalidDNSLabelChars matches runs of characters not allowed in a DNS label.
+var invalidDNSLabelChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// normalizeDNSLabel turns an arbitrary string into a valid DNS label:
+// lowercased, with invalid characters replaced by '-' and no leading or
+// trailing hyphens. E.g. "My_App.v2" becomes "my-app-v2".
+func normalizeDNSLabel(s string) string {
+	s = invalidDNSLabelChars.ReplaceAllString(strings.ToLower(s), "-")
+	return strings.Trim(s, "-")
+}
+
+// templateFuncs are the helper functions available to name templates.
+// Argument order follows sprig, so they work at the end of a pipeline:
+// {{ .Name | replace "_" "-" | trimSuffix "-1" }}
+var templateFuncs = template.FuncMap{
+	"normalize":  normalizeDNSLabel,
+	"lower":      strings.ToLower,
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+}
+
+// newContainerTemplate parses a name template, making the helper functions available.
+func newContainerTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// newContainerTemplateData collects the template-visible metadata of a container.
+func newContainerTemplateData(container *dockerapi.Container) containerTemplateData {
+	data := containerTemplateData{
+		Name:   normalizeContainerName(container),
+		Labels: map[string]string{},
+	}
+	if container.Config != nil {
+		data.Hostname = container.Config.Hostname
+		data.Image = container.Config.Image
+		if container.Config.Labels != nil {
+			data.Labels = container.Config.Labels
+		}
+		data.Compose = composeTemplateData{
+			Project: data.Labels["com.docker.compose.project"],
+			Service: data.Labels["com.docker.compose.service"],
+			Number:  data.Labels["com.docker.compose.container-number"],
+		}
+	}
+	if container.NetworkSettings != nil {
+		for network := range container.NetworkSettings.Networks {
+			data.Networks = append(data.Networks, network)
+		}
+		sort.Strings(data.Networks)
+	}
+	return data
+}
+
+// TemplateResolver sets names by executing a Go text/template over the
+// container metadata, e.g. {{.Compose.Service}}.{{.Compose.Project}}.lan
+type TemplateResolver struct {
+	template *template.Template
+}
+
+// NewTemplateResolver parses text and returns a resolver for it.
+func NewTemplateResolver(text string) (*TemplateResolver, error) {
+	tmpl, err := newContainerTemplate("name_template", text)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateResolver{template: tmpl}, nil
+}
+
+func (resolver TemplateResolver) resolve(container *dockerapi.Container) ([]string, error) {
+	var buf bytes.Buffer
+	if err := resolver.template.Execute(&buf, newContainerTemplateData(container)); err != nil {
+		return nil, fmt.Errorf("executing name_template for container %s: %w", shortID(container.ID), err)
+	}
+
+	// An empty result (e.g. from {{if .Compose.Project}}...{{end}}) means
+	// the template does not apply to this container.
+	domain := strings.TrimSuffix(strings.TrimSpace(buf.String()), ".")
+	if domain == "" {
+		return nil, nil
+	}
+	return []string{domain}, nil
+}
@@ -0,0 +1,117 @@
+package dockerdiscovery
+
+import (
+	"testing"
+
+	"github.com/coredns/caddy"
+	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func genComposeContainer() *dockerapi.Container {
+	return &dockerapi.Container{
+		ID:   "fa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7",
+		Name: "/myapp-web-1",
+		Config: &dockerapi.Config{
+			Hostname: "4f2a9c1d",
+			Image:    "nginx:1.25",
+			Labels: map[string]string{
+				"com.docker.compose.project":          "myapp",
+				"com.docker.compose.service":          "web",
+				"com.docker.compose.container-number": "1",
+				"tier":                                "Front_End",
+			},
+		},
+		NetworkSettings: &dockerapi.NetworkSettings{
+			Networks: map[string]dockerapi.ContainerNetwork{
+				"proxy":         {},
+				"myapp_default": {},
+			},
+		},
+	}
+}
+
+func TestTemplateResolver(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected []string
+	}{
+		{"compose replica", "{{.Compose.Service}}-{{.Compose.Number}}.{{.Compose.Project}}.lan", []string{"web-1.myapp.lan"}},
+		{"container name", "{{.Name}}.docker.lan", []string{"myapp-web-1.docker.lan"}},
+		{"hostname", "{{.Hostname}}.hosts.lan", []string{"4f2a9c1d.hosts.lan"}},
+		{"label with normalize", "{{normalize .Labels.tier}}.lan", []string{"front-end.lan"}},
+		{"missing label", "x{{.Labels.missing}}.lan", []string{"x.lan"}},
+		{"pipeline helpers", `{{.Name | trimSuffix "-1" | replace "-" "."}}.lan`, []string{"myapp.web.lan"}},
+		{"lower", "{{lower .Labels.tier}}.lan", []string{"front_end.lan"}},
+		{"first network", "{{index .Networks 0}}.lan", []string{"myapp_default.lan"}},
+		{"image", `{{.Image | replace ":" "-" | replace "." "-"}}.lan`, []string{"nginx-1-25.lan"}},
+		{"trailing dot trimmed", "{{.Name}}.lan.", []string{"myapp-web-1.lan"}},
+		{"conditional empty", "{{if .Labels.missing}}{{.Name}}.lan{{end}}", nil},
+	}
+
+	container := genComposeContainer()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resolver, err := NewTemplateResolver(tc.template)
+			assert.Nil(t, err)
+			domains, err := resolver.resolve(container)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, domains)
+		})
+	}
+}
+
+func TestTemplateResolverWithoutCompose(t *testing.T) {
+	resolver, err := NewTemplateResolver("{{with .Compose.Project}}{{$.Compose.Service}}.{{.}}.lan{{end}}")
+	assert.Nil(t, err)
+
+	container := &dockerapi.Container{
+		ID:     "ab155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7",
+		Name:   "/standalone",
+		Config: &dockerapi.Config{},
+	}
+	domains, err := resolver.resolve(container)
+	assert.Nil(t, err)
+	assert.Empty(t, domains)
+
+	domains, err = resolver.resolve(genComposeContainer())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"web.myapp.lan"}, domains)
+}
+
+func TestNormalizeDNSLabel(t *testing.T) {
+	assert.Equal(t, "my-app-v2", normalizeDNSLabel("My_App.v2"))
+	assert.Equal(t, "web", normalizeDNSLabel("--web--"))
+	assert.Equal(t, "a-b", normalizeDNSLabel("a   b"))
+	assert.Equal(t, "", normalizeDNSLabel("___"))
+}
+
+func TestNameTemplateConfig(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	name_template {{.Compose.Service}}.{{.Compose.Project}}.lan
+	name_template "{{ .Name }}.docker.lan"
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	// label resolver + two template resolvers
+	assert.Equal(t, 3, len(dd.resolvers))
+
+	domains, _, _, _ := dd.resolveDomainsByContainer(genComposeContainer())
+	assert.ElementsMatch(t, []string{"web.myapp.lan", "myapp-web-1.docker.lan"}, domains)
+}
+
+func TestNameTemplateConfigInvalid(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	name_template {{.Name
+}`)
+	_, err := createPlugin(c)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid name_template")
+
+	c = caddy.NewTestController("dns", `docker {
+	name_template
+}`)
+	_, err = createPlugin(c)
+	assert.NotNil(t, err)
+}
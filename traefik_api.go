@@ -0,0 +1,150 @@
+package dockerdiscovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// traefikAPIKeyPrefix prefixes the containerInfoMap keys of entries
+// published from the Traefik API, one per router.
+const traefikAPIKeyPrefix = "traefik-api:"
+
+const defaultTraefikAPIInterval = 30 * time.Second
+
+// traefikAPIPageSize is the per_page of router list requests (Traefik's
+// maximum is 100 per page by default as well).
+const traefikAPIPageSize = 100
+
+// TraefikAPIProvider polls Traefik's API for the routers of every provider
+// Traefik knows about (Docker, file, Consul, ...), like coredns-traefik.
+type TraefikAPIProvider struct {
+	url      string // base URL of the API, e.g. http://traefik:8080
+	interval time.Duration
+	client   *http.Client
+}
+
+func NewTraefikAPIProvider(apiURL string, interval time.Duration) *TraefikAPIProvider {
+	return &TraefikAPIProvider{
+		url:      strings.TrimSuffix(apiURL, "/"),
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// traefikAPIRouter is a router as listed by /api/http/routers and
+// /api/tcp/routers.
+type traefikAPIRouter struct {
+	Name        string   `json:"name"`
+	Rule        string   `json:"rule"`
+	EntryPoints []string `json:"entryPoints"`
+	Status      string   `json:"status"`
+	TLS         *struct {
+		Domains []struct {
+			Main string   `json:"main"`
+			SANs []string `json:"sans"`
+		} `json:"domains"`
+	} `json:"tls"`
+}
+
+// routers fetches the enabled HTTP and TCP routers, by router name
+// (name@provider).
+func (p *TraefikAPIProvider) routers() (map[string]*traefikRouter, error) {
+	routers := make(map[string]*traefikRouter)
+	for _, protocol := range []string{"http", "tcp"} {
+		listed, err := p.listRouters(protocol)
+		if err != nil {
+			return nil, err
+		}
+		for _, apiRouter := range listed {
+			if apiRouter.Status == "disabled" {
+				continue
+			}
+			router := &traefikRouter{
+				protocol:    protocol,
+				name:        apiRouter.Name,
+				rule:        apiRouter.Rule,
+				entrypoints: apiRouter.EntryPoints,
+			}
+			if apiRouter.TLS != nil {
+				for _, domain := range apiRouter.TLS.Domains {
+					if domain.Main != "" {
+						router.tlsDomains = append(router.tlsDomains, domain.Main)
+					}
+					router.tlsDomains = append(router.tlsDomains, domain.SANs...)
+				}
+			}
+			routers[protocol+"/"+apiRouter.Name] = router
+		}
+	}
+	return routers, nil
+}
+
+// listRouters fetches every page of /api/<protocol>/routers. Traefik sets
+// X-Next-Page to the next page, or to 1 on the last one.
+func (p *TraefikAPIProvider) listRouters(protocol string) ([]traefikAPIRouter, error) {
+	var routers []traefikAPIRouter
+	for page := 1; ; {
+		query := url.Values{"page": {strconv.Itoa(page)}, "per_page": {strconv.Itoa(traefikAPIPageSize)}}
+		resp, err := p.client.Get(p.url + "/api/" + protocol + "/routers?" + query.Encode())
+		if err != nil {
+			return nil, err
+		}
+
+		var pageRouters []traefikAPIRouter
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("traefik api: GET /api/%s/routers: %s", protocol, resp.Status)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&pageRouters)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		routers = append(routers, pageRouters...)
+
+		next, err := strconv.Atoi(resp.Header.Get("X-Next-Page"))
+		if err != nil || next <= page {
+			return routers, nil
+		}
+		page = next
+	}
+}
+
+// syncTraefikAPI publishes the hosts of the routers listed by the Traefik
+// API. When the API can't be reached, the hosts of the last poll are kept.
+func (dd *DockerDiscovery) syncTraefikAPI() {
+	routers, err := dd.traefikAPI.routers()
+	if err != nil {
+		log.Printf("[docker] Error polling traefik api %s: %s", dd.traefikAPI.url, err)
+		return
+	}
+
+	entries := make(map[string]*ContainerInfo)
+	for key, router := range routers {
+		domains, targets := dd.traefikRouterDomains("traefik api", []*traefikRouter{router})
+		domains, targets = dd.rewriteCNAMEDomains(domains, targets)
+		entries[traefikAPIKeyPrefix+key] = &ContainerInfo{cnameDomains: domains, cnameTargets: targets}
+	}
+	dd.syncExternalEntries(traefikAPIKeyPrefix, entries)
+}
+
+// pollTraefikAPI publishes the Traefik API's hosts every interval until
+// stop is closed.
+func (dd *DockerDiscovery) pollTraefikAPI(stop <-chan struct{}) {
+	ticker := time.NewTicker(dd.traefikAPI.interval)
+	defer ticker.Stop()
+	for {
+		dd.syncTraefikAPI()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package dockerdiscovery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTraefikAPI serves /api/http/routers and /api/tcp/routers from
+// mutable router lists, paginated like Traefik.
+type fakeTraefikAPI struct {
+	mutex   sync.Mutex
+	routers map[string][]map[string]interface{} // protocol -> routers
+	fail    bool
+}
+
+func (f *fakeTraefikAPI) set(protocol string, routers ...map[string]interface{}) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.routers[protocol] = routers
+}
+
+func (f *fakeTraefikAPI) setFail(fail bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.fail = fail
+}
+
+func (f *fakeTraefikAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.fail {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var routers []map[string]interface{}
+	switch r.URL.Path {
+	case "/api/http/routers":
+		routers = f.routers["http"]
+	case "/api/tcp/routers":
+		routers = f.routers["tcp"]
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	start, end := (page-1)*perPage, page*perPage
+	if end >= len(routers) {
+		end = len(routers)
+		w.Header().Set("X-Next-Page", "1")
+	} else {
+		w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+	}
+	if start > end {
+		start = end
+	}
+	json.NewEncoder(w).Encode(routers[start:end])
+}
+
+func newFakeTraefikAPI() (*fakeTraefikAPI, *httptest.Server) {
+	fake := &fakeTraefikAPI{routers: make(map[string][]map[string]interface{})}
+	return fake, httptest.NewServer(fake)
+}
+
+func apiRouter(name string, rule string, entryPoints ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"rule":        rule,
+		"entryPoints": entryPoints,
+		"status":      "enabled",
+		"provider":    "docker",
+	}
+}
+
+func TestTraefikAPIProviderRouters(t *testing.T) {
+	fake, server := newFakeTraefikAPI()
+	defer server.Close()
+
+	var many []map[string]interface{}
+	for i := 0; i < 250; i++ {
+		many = append(many, apiRouter("r"+strconv.Itoa(i)+"@docker", "Host(`r"+strconv.Itoa(i)+".example.com`)"))
+	}
+	withTLS := apiRouter("nas@file", "Host(`nas.example.com`)", "websecure")
+	withTLS["tls"] = map[string]interface{}{
+		"domains": []map[string]interface{}{{"main": "example.com", "sans": []string{"*.example.com"}}},
+	}
+	disabled := apiRouter("broken@docker", "Host(`broken.example.com`)")
+	disabled["status"] = "disabled"
+	fake.set("http", append(many, withTLS, disabled)...)
+	fake.set("tcp", apiRouter("db@consul", "HostSNI(`db.example.com`)"))
+
+	routers, err := NewTraefikAPIProvider(server.URL+"/", time.Minute).routers()
+	assert.Nil(t, err)
+	assert.Equal(t, 252, len(routers))
+	assert.Equal(t, &traefikRouter{
+		protocol:    "http",
+		name:        "nas@file",
+		rule:        "Host(`nas.example.com`)",
+		entrypoints: []string{"websecure"},
+		tlsDomains:  []string{"example.com", "*.example.com"},
+	}, routers["http/nas@file"])
+	assert.Equal(t, "tcp", routers["tcp/db@consul"].protocol)
+	assert.Nil(t, routers["http/broken@docker"])
+
+	fake.setFail(true)
+	_, err = NewTraefikAPIProvider(server.URL, time.Minute).routers()
+	assert.NotNil(t, err)
+}
+
+func TestSyncTraefikAPI(t *testing.T) {
+	fake, server := newFakeTraefikAPI()
+	defer server.Close()
+	fake.set("http",
+		apiRouter("app@docker", "Host(`app.example.com`) && PathPrefix(`/api`)"),
+		apiRouter("nas@file", "Host(`nas.example.com`)", "websecure"),
+	)
+	fake.set("tcp", apiRouter("db@consul", "HostSNI(`db.example.com`)"))
+
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	traefik_cname traefik.lan
+	traefik_tcp_cname tcp.lan
+	traefik_api `+server.URL+` 5s
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Second, dd.traefikAPI.interval)
+	dd.syncTraefikAPI()
+
+	expected := map[string]string{
+		"app.example.com.": "traefik.lan",
+		"nas.example.com.": "traefik.lan",
+		"db.example.com.":  "tcp.lan",
+	}
+	for name, target := range expected {
+		result, err := dd.containerInfoByDomain(name)
+		assert.Nil(t, err)
+		if assert.NotNil(t, result, name) {
+			assert.Equal(t, target, result.target.cname, name)
+		}
+	}
+
+	// Routers that disappear between polls lose their records
+	fake.set("http", apiRouter("app@docker", "Host(`app.example.com`)"))
+	dd.syncTraefikAPI()
+	result, _ := dd.containerInfoByDomain("nas.example.com.")
+	assert.Nil(t, result)
+	result, _ = dd.containerInfoByDomain("app.example.com.")
+	assert.NotNil(t, result)
+
+	// An unreachable API keeps the records of the last poll
+	fake.setFail(true)
+	dd.syncTraefikAPI()
+	result, _ = dd.containerInfoByDomain("app.example.com.")
+	assert.NotNil(t, result)
+	assert.Equal(t, 2, len(dd.containerInfoMap))
+}
+
+func TestTraefikAPIConfig(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker {
+	traefik_cname traefik.lan
+	traefik_api http://traefik:8080
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://traefik:8080", dd.traefikAPI.url)
+	assert.Equal(t, defaultTraefikAPIInterval, dd.traefikAPI.interval)
+
+	for _, block := range []string{
+		"traefik_api http://traefik:8080",
+		"traefik_cname traefik.lan\ntraefik_api",
+		"traefik_cname traefik.lan\ntraefik_api traefik:8080",
+		"traefik_cname traefik.lan\ntraefik_api http://traefik:8080 often",
+		"traefik_cname traefik.lan\ntraefik_api http://traefik:8080 0s",
+		"traefik_cname traefik.lan\ntraefik_api http://traefik:8080 5s extra",
+	} {
+		c = caddy.NewTestController("dns", "docker {\n"+block+"\n}")
+		_, err = createPlugin(c)
+		assert.NotNil(t, err, block)
+	}
+}
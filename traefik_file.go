@@ -0,0 +1,290 @@
+package dockerdiscovery
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// traefikFileKeyPrefix prefixes the containerInfoMap keys of entries
+// published from Traefik's file provider, one per configuration file.
+const traefikFileKeyPrefix = "traefik-file:"
+
+// traefikFileDebounce groups the bursts of events editors and config
+// management produce when writing a file into a single reload.
+const traefikFileDebounce = 200 * time.Millisecond
+
+// TraefikFileProvider reads routers from the dynamic configuration of
+// Traefik's file provider: a single YAML/TOML file, or a directory of them
+// (read recursively, like Traefik does).
+type TraefikFileProvider struct {
+	path string
+}
+
+func NewTraefikFileProvider(path string) *TraefikFileProvider {
+	return &TraefikFileProvider{path: filepath.Clean(path)}
+}
+
+// traefikDynamicConfig is the part of Traefik's dynamic configuration
+// the provider reads: the HTTP and TCP routers.
+type traefikDynamicConfig struct {
+	HTTP traefikFileRouters `yaml:"http" toml:"http"`
+	TCP  traefikFileRouters `yaml:"tcp" toml:"tcp"`
+}
+
+type traefikFileRouters struct {
+	Routers map[string]traefikFileRouter `yaml:"routers" toml:"routers"`
+}
+
+type traefikFileRouter struct {
+	Rule        string   `yaml:"rule" toml:"rule"`
+	EntryPoints []string `yaml:"entryPoints" toml:"entryPoints"`
+	TLS         struct {
+		Domains []struct {
+			Main string   `yaml:"main" toml:"main"`
+			SANs []string `yaml:"sans" toml:"sans"`
+		} `yaml:"domains" toml:"domains"`
+	} `yaml:"tls" toml:"tls"`
+}
+
+func isTraefikConfigFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml", ".toml":
+		return true
+	}
+	return false
+}
+
+// files returns the configuration files to read, sorted.
+func (p *TraefikFileProvider) files() ([]string, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{p.path}, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(p.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && isTraefikConfigFile(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// loadTraefikFileRouters reads the HTTP and TCP routers of a configuration
+// file, sorted by protocol and name.
+func loadTraefikFileRouters(path string) ([]*traefikRouter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config traefikDynamicConfig
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		err = toml.Unmarshal(data, &config)
+	} else {
+		err = yaml.Unmarshal(data, &config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid traefik configuration %s: %w", path, err)
+	}
+
+	var routers []*traefikRouter
+	for _, protocol := range []string{"http", "tcp"} {
+		declared := config.HTTP.Routers
+		if protocol == "tcp" {
+			declared = config.TCP.Routers
+		}
+		names := make([]string, 0, len(declared))
+		for name := range declared {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			declaredRouter := declared[name]
+			router := &traefikRouter{
+				protocol:    protocol,
+				name:        name + "@file",
+				rule:        declaredRouter.Rule,
+				entrypoints: declaredRouter.EntryPoints,
+			}
+			for _, domain := range declaredRouter.TLS.Domains {
+				if domain.Main != "" {
+					router.tlsDomains = append(router.tlsDomains, domain.Main)
+				}
+				router.tlsDomains = append(router.tlsDomains, domain.SANs...)
+			}
+			routers = append(routers, router)
+		}
+	}
+	return routers, nil
+}
+
+// watch calls onChange once the configuration is watched, then after
+// configuration files were written, created or removed, until stop is
+// closed.
+func (p *TraefikFileProvider) watch(stop <-chan struct{}, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// Watch directories rather than files: editors replace files on save,
+	// which would silently end a watch on the file itself.
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	root := p.path
+	if !info.IsDir() {
+		root = filepath.Dir(p.path)
+	}
+	if info.IsDir() {
+		err = filepath.WalkDir(p.path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return err
+			}
+			return watcher.Add(path)
+		})
+	} else {
+		err = watcher.Add(filepath.Dir(p.path))
+	}
+	if err != nil {
+		return err
+	}
+	onChange()
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return errors.New("traefik file watcher closed")
+			}
+			if filepath.Clean(event.Name) == root && (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) {
+				return fmt.Errorf("%s was removed", root)
+			}
+			if info.IsDir() && event.Has(fsnotify.Create) {
+				if created, err := os.Stat(event.Name); err == nil && created.IsDir() {
+					if err := watcher.Add(event.Name); err != nil {
+						log.Printf("[docker] Error watching %s: %s", event.Name, err)
+					}
+					reload = time.After(traefikFileDebounce)
+					continue
+				}
+			}
+			// Any event next to a single file may replace it, e.g. the
+			// swap of the ..data symlink of a Kubernetes ConfigMap
+			if info.IsDir() && !isTraefikConfigFile(event.Name) {
+				continue
+			}
+			reload = time.After(traefikFileDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return errors.New("traefik file watcher closed")
+			}
+			log.Printf("[docker] Error watching %s: %s", p.path, err)
+		case <-reload:
+			reload = nil
+			onChange()
+		}
+	}
+}
+
+// syncTraefikFile publishes the hosts of the routers of every file of the
+// Traefik file provider. Files that fail to parse (e.g. while being
+// written) keep their previous hosts; removed ones lose them.
+func (dd *DockerDiscovery) syncTraefikFile() {
+	files, err := dd.traefikFile.files()
+	if err != nil {
+		if _, statErr := os.Stat(dd.traefikFile.path); !errors.Is(statErr, fs.ErrNotExist) {
+			log.Printf("[docker] Error reading traefik file provider %s: %s", dd.traefikFile.path, err)
+			return
+		}
+		// Removed, like the routers Traefik read from it
+		log.Printf("[docker] Traefik file provider %s doesn't exist", dd.traefikFile.path)
+		files = nil
+	}
+
+	entries := make(map[string]*ContainerInfo)
+	for _, path := range files {
+		key := traefikFileKeyPrefix + path
+		routers, err := loadTraefikFileRouters(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Printf("[docker] Error reading %s: %s", path, err)
+			entries[key] = nil
+			continue
+		}
+		domains, targets := dd.traefikRouterDomains("file "+path, routers)
+		domains, targets = dd.rewriteCNAMEDomains(domains, targets)
+		entries[key] = &ContainerInfo{cnameDomains: domains, cnameTargets: targets}
+	}
+	dd.syncExternalEntries(traefikFileKeyPrefix, entries)
+}
+
+// traefikRouterDomains returns the hosts of routers declared outside of
+// container labels as CNAME domains, with their targets when they don't
+// use the default one.
+func (dd *DockerDiscovery) traefikRouterDomains(source string, routers []*traefikRouter) ([]string, map[string]recordTarget) {
+	var domains []string
+	targets := make(map[string]recordTarget)
+	seen := make(map[string]bool)
+	for _, router := range routers {
+		if router.protocol == "udp" || !dd.traefikResolver.onEntrypoints(router) {
+			continue
+		}
+		for _, name := range dd.traefikResolver.routerHosts(source, router) {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			domains = append(domains, name)
+			if target, ok := dd.traefikTarget(traefikHost{name: name, protocol: router.protocol, entrypoints: router.entrypoints}); ok {
+				targets[name] = target
+			}
+		}
+	}
+	return domains, targets
+}
+
+// watchTraefikFile publishes the file provider's hosts and keeps them up to
+// date until stop is closed, watching the path again, with the backoff of
+// Docker endpoints, when it can't be watched (e.g. it doesn't exist yet, or
+// was removed). Meanwhile the hosts of files that don't exist anymore are
+// removed.
+func (dd *DockerDiscovery) watchTraefikFile(stop <-chan struct{}) {
+	retryWithBackoff(stop, func() error {
+		err := dd.traefikFile.watch(stop, dd.syncTraefikFile)
+		if err != nil {
+			dd.syncTraefikFile()
+		}
+		return err
+	}, func(err error, delay time.Duration) {
+		log.Printf("[docker] Error watching traefik file provider %s: %s. Retrying in %s", dd.traefikFile.path, err, delay)
+	})
+}
@@ -0,0 +1,310 @@
+package dockerdiscovery
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/stretchr/testify/assert"
+)
+
+const traefikFileYAML = `
+http:
+  routers:
+    nas:
+      rule: "Host(` + "`nas.example.com`" + `) || Host(` + "`files.example.com`" + `)"
+      entryPoints:
+        - websecure
+      service: nas
+      tls:
+        domains:
+          - main: example.com
+            sans:
+              - "*.example.com"
+    internal:
+      rule: "Host(` + "`router.home.arpa`" + `)"
+      entryPoints: [web-internal]
+  services:
+    nas:
+      loadBalancer:
+        servers:
+          - url: http://192.168.1.10:5000
+tcp:
+  routers:
+    db:
+      rule: "HostSNI(` + "`db.example.com`" + `)"
+`
+
+const traefikFileTOML = `
+[http.routers.printer]
+  rule = "Host(` + "`printer.lan`" + `)"
+  entryPoints = ["web"]
+
+  [[http.routers.printer.tls.domains]]
+    main = "lan"
+    sans = ["*.lan"]
+
+[udp.routers.dns]
+  entryPoints = ["dns"]
+`
+
+func writeTraefikFile(t *testing.T, path string, content string) {
+	t.Helper()
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestLoadTraefikFileRouters(t *testing.T) {
+	dir := t.TempDir()
+	writeTraefikFile(t, filepath.Join(dir, "routes.yml"), traefikFileYAML)
+	writeTraefikFile(t, filepath.Join(dir, "printer.toml"), traefikFileTOML)
+
+	routers, err := loadTraefikFileRouters(filepath.Join(dir, "routes.yml"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(routers))
+	assert.Equal(t, traefikRouter{
+		protocol:    "http",
+		name:        "internal@file",
+		rule:        "Host(`router.home.arpa`)",
+		entrypoints: []string{"web-internal"},
+	}, *routers[0])
+	assert.Equal(t, "nas@file", routers[1].name)
+	assert.Equal(t, []string{"websecure"}, routers[1].entrypoints)
+	assert.Equal(t, []string{"example.com", "*.example.com"}, routers[1].tlsDomains)
+	assert.Equal(t, "tcp", routers[2].protocol)
+
+	routers, err = loadTraefikFileRouters(filepath.Join(dir, "printer.toml"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(routers))
+	assert.Equal(t, "Host(`printer.lan`)", routers[0].rule)
+	assert.Equal(t, []string{"lan", "*.lan"}, routers[0].tlsDomains)
+
+	writeTraefikFile(t, filepath.Join(dir, "broken.yaml"), "http: [")
+	_, err = loadTraefikFileRouters(filepath.Join(dir, "broken.yaml"))
+	assert.NotNil(t, err)
+}
+
+func TestTraefikFileProviderFiles(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "sub"), 0o755))
+	writeTraefikFile(t, filepath.Join(dir, "b.yml"), "")
+	writeTraefikFile(t, filepath.Join(dir, "sub", "a.toml"), "")
+	writeTraefikFile(t, filepath.Join(dir, "README.md"), "")
+
+	files, err := NewTraefikFileProvider(dir).files()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "b.yml"), filepath.Join(dir, "sub", "a.toml")}, files)
+
+	files, err = NewTraefikFileProvider(filepath.Join(dir, "b.yml")).files()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "b.yml")}, files)
+
+	_, err = NewTraefikFileProvider(filepath.Join(dir, "missing")).files()
+	assert.NotNil(t, err)
+}
+
+func TestSyncTraefikFile(t *testing.T) {
+	dir := t.TempDir()
+	routes := filepath.Join(dir, "routes.yml")
+	printer := filepath.Join(dir, "printer.toml")
+	writeTraefikFile(t, routes, traefikFileYAML)
+	writeTraefikFile(t, printer, traefikFileTOML)
+
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	traefik_cname traefik.lan
+	traefik_tcp_cname tcp.lan
+	traefik_entrypoints websecure web
+	traefik_tls_domains
+	traefik_file `+dir+`
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	dd.syncTraefikFile()
+
+	expected := map[string]string{
+		"nas.example.com.":   "traefik.lan",
+		"files.example.com.": "traefik.lan",
+		"example.com.":       "traefik.lan",
+		"any.example.com.":   "traefik.lan",
+		"db.example.com.":    "tcp.lan",
+		"printer.lan.":       "traefik.lan",
+	}
+	for name, target := range expected {
+		result, err := dd.containerInfoByDomain(name)
+		assert.Nil(t, err)
+		if assert.NotNil(t, result, name) {
+			assert.True(t, result.isCNAME, name)
+			assert.Equal(t, target, result.target.cname, name)
+		}
+	}
+	// Router on an entrypoint that isn't published
+	result, _ := dd.containerInfoByDomain("router.home.arpa.")
+	assert.Nil(t, result)
+
+	// Files that fail to parse keep their hosts, removed files lose them
+	writeTraefikFile(t, routes, "http: [")
+	assert.Nil(t, os.Remove(printer))
+	dd.syncTraefikFile()
+	result, _ = dd.containerInfoByDomain("nas.example.com.")
+	assert.NotNil(t, result)
+	result, _ = dd.containerInfoByDomain("printer.lan.")
+	assert.Nil(t, result)
+
+	writeTraefikFile(t, routes, "http:\n  routers:\n    nas:\n      rule: Host(`storage.example.com`)\n")
+	dd.syncTraefikFile()
+	result, _ = dd.containerInfoByDomain("nas.example.com.")
+	assert.Nil(t, result)
+	result, _ = dd.containerInfoByDomain("storage.example.com.")
+	assert.NotNil(t, result)
+	assert.Equal(t, 1, len(dd.containerInfoMap))
+
+	// The configuration is removed altogether
+	assert.Nil(t, os.RemoveAll(dir))
+	dd.syncTraefikFile()
+	assert.Empty(t, dd.containerInfoMap)
+}
+
+func TestSyncExternalEntriesCloudflare(t *testing.T) {
+	mock := newMockCloudflareAPI()
+	dd := NewDockerDiscovery("unix:///var/run/docker.sock")
+	dd.cloudflareSyncer = NewCloudflareSyncerWithAPI(&CloudflareConfig{
+		TargetDomain:   "traefik.homelab.net",
+		ExcludeDomains: make(map[string]bool),
+		Zones:          []CloudflareZone{{Domain: "homelab.net", ZoneID: "zone_1"}},
+	}, mock)
+
+	dd.syncExternalEntries(traefikFileKeyPrefix, map[string]*ContainerInfo{
+		traefikFileKeyPrefix + "a.yml": {cnameDomains: []string{"nas.homelab.net", "git.homelab.net"}},
+	})
+	assert.Eventually(t, func() bool { return mock.recordCount() == 2 }, time.Second, 10*time.Millisecond)
+
+	dd.syncExternalEntries(traefikFileKeyPrefix, map[string]*ContainerInfo{
+		traefikFileKeyPrefix + "a.yml": {cnameDomains: []string{"nas.homelab.net"}},
+	})
+	assert.Eventually(t, func() bool { return mock.recordCount() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "nas.homelab.net", mock.allRecords()[0].Name)
+}
+
+func TestTraefikFileProviderWatch(t *testing.T) {
+	dir := t.TempDir()
+	provider := NewTraefikFileProvider(dir)
+
+	changes := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- provider.watch(stop, func() { changes <- struct{}{} })
+	}()
+
+	// Once watching, the configuration is read
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no initial reload")
+	}
+	writeTraefikFile(t, filepath.Join(dir, "routes.yml"), traefikFileYAML)
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reload after writing a configuration file")
+	}
+
+	close(stop)
+	assert.Nil(t, <-done)
+}
+
+func TestTraefikFileWatchSymlinkSwap(t *testing.T) {
+	// A Kubernetes ConfigMap mount: files are links through ..data, which
+	// is swapped atomically on updates
+	dir := t.TempDir()
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "..v1"), 0o755))
+	writeTraefikFile(t, filepath.Join(dir, "..v1", "dynamic.yml"), traefikFileYAML)
+	assert.Nil(t, os.Symlink("..v1", filepath.Join(dir, "..data")))
+	assert.Nil(t, os.Symlink(filepath.Join("..data", "dynamic.yml"), filepath.Join(dir, "dynamic.yml")))
+	provider := NewTraefikFileProvider(filepath.Join(dir, "dynamic.yml"))
+
+	changes := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- provider.watch(stop, func() { changes <- struct{}{} })
+	}()
+
+	<-changes
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "..v2"), 0o755))
+	writeTraefikFile(t, filepath.Join(dir, "..v2", "dynamic.yml"), traefikFileTOML)
+	assert.Nil(t, os.Symlink("..v2", filepath.Join(dir, "..data_tmp")))
+	assert.Nil(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reload after swapping ..data")
+	}
+
+	close(stop)
+	assert.Nil(t, <-done)
+}
+
+func TestWatchTraefikFileRetries(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "dynamic")
+	assert.Nil(t, os.Mkdir(dir, 0o755))
+	writeTraefikFile(t, filepath.Join(dir, "printer.toml"), traefikFileTOML)
+
+	c := caddy.NewTestController("dns", `docker {
+	traefik_cname traefik.lan
+	traefik_file `+dir+`
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		dd.watchTraefikFile(stop)
+		close(done)
+	}()
+	lookup := func(name string) bool {
+		result, _ := dd.containerInfoByDomain(name)
+		return result != nil
+	}
+	assert.Eventually(t, func() bool { return lookup("printer.lan.") }, 2*time.Second, 10*time.Millisecond)
+
+	// The directory is replaced: watched again once it's back
+	assert.Nil(t, os.RemoveAll(dir))
+	assert.Eventually(t, func() bool { return !lookup("printer.lan.") }, 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, os.Mkdir(dir, 0o755))
+	writeTraefikFile(t, filepath.Join(dir, "routes.yml"), traefikFileYAML)
+	assert.Eventually(t, func() bool { return lookup("nas.example.com.") && !lookup("printer.lan.") }, 5*time.Second, 10*time.Millisecond)
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("watchTraefikFile didn't stop")
+	}
+}
+
+func TestTraefikFileConfig(t *testing.T) {
+	// Requires a target
+	c := caddy.NewTestController("dns", `docker {
+	traefik_file `+t.TempDir()+`
+}`)
+	_, err := createPlugin(c)
+	assert.NotNil(t, err)
+
+	// but not an existing path: it's watched once it's created
+	c = caddy.NewTestController("dns", `docker {
+	traefik_cname traefik.lan
+	traefik_file /nonexistent/traefik/dynamic
+}`)
+	_, err = createPlugin(c)
+	assert.Nil(t, err)
+
+	c = caddy.NewTestController("dns", `docker {
+	traefik_file
+}`)
+	_, err = createPlugin(c)
+	assert.NotNil(t, err)
+}
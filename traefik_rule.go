@@ -0,0 +1,361 @@
+package dockerdiscovery
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Traefik router rules are boolean expressions over matchers, e.g.
+//
+//	(Host(`a.com`) || Host(`b.com`)) && !PathPrefix(`/admin`)
+//
+// This file parses both the v2 and v3 syntax (v2 matchers take several
+// arguments, v3 HostRegexp takes a plain regexp) and works out which hosts
+// a rule can actually match.
+
+type ruleTokenKind int
+
+const (
+	ruleTokenIdent ruleTokenKind = iota
+	ruleTokenString
+	ruleTokenLParen
+	ruleTokenRParen
+	ruleTokenComma
+	ruleTokenAnd
+	ruleTokenOr
+	ruleTokenNot
+	ruleTokenEOF
+)
+
+type ruleToken struct {
+	kind  ruleTokenKind
+	value string
+	pos   int
+}
+
+// tokenizeTraefikRule splits a rule into tokens. Strings can be quoted with
+// backticks, double quotes or single quotes.
+func tokenizeTraefikRule(rule string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	for i := 0; i < len(rule); {
+		c := rule[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, ruleToken{kind: ruleTokenLParen, pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, ruleToken{kind: ruleTokenRParen, pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, ruleToken{kind: ruleTokenComma, pos: i})
+			i++
+		case c == '!':
+			tokens = append(tokens, ruleToken{kind: ruleTokenNot, pos: i})
+			i++
+		case c == '&' || c == '|':
+			if i+1 >= len(rule) || rule[i+1] != c {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+			}
+			kind := ruleTokenAnd
+			if c == '|' {
+				kind = ruleTokenOr
+			}
+			tokens = append(tokens, ruleToken{kind: kind, pos: i})
+			i += 2
+		case c == '`' || c == '"' || c == '\'':
+			end := strings.IndexByte(rule[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, ruleToken{kind: ruleTokenString, value: rule[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case isRuleIdentChar(c):
+			start := i
+			for i < len(rule) && isRuleIdentChar(rule[i]) {
+				i++
+			}
+			tokens = append(tokens, ruleToken{kind: ruleTokenIdent, value: rule[start:i], pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+		}
+	}
+	return append(tokens, ruleToken{kind: ruleTokenEOF, pos: len(rule)}), nil
+}
+
+func isRuleIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// ruleNode is a node of a parsed rule: a matcher call, or a boolean
+// operator ("and", "or", "not") over child nodes.
+type ruleNode struct {
+	op       string
+	matcher  string
+	args     []string
+	children []*ruleNode
+}
+
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+// parseTraefikRule parses a router rule into an expression tree.
+func parseTraefikRule(rule string) (*ruleNode, error) {
+	tokens, err := tokenizeTraefikRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	p := &ruleParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != ruleTokenEOF {
+		return nil, fmt.Errorf("unexpected token at position %d", tok.pos)
+	}
+	return node, nil
+}
+
+func (p *ruleParser) peek() ruleToken { return p.tokens[p.pos] }
+
+func (p *ruleParser) next() ruleToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != ruleTokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *ruleParser) expect(kind ruleTokenKind, what string) (ruleToken, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, fmt.Errorf("expected %s at position %d", what, tok.pos)
+	}
+	return tok, nil
+}
+
+// parseOr: and ( "||" and )*
+func (p *ruleParser) parseOr() (*ruleNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == ruleTokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &ruleNode{op: "or", children: []*ruleNode{left, right}}
+	}
+	return left, nil
+}
+
+// parseAnd: unary ( "&&" unary )*
+func (p *ruleParser) parseAnd() (*ruleNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == ruleTokenAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &ruleNode{op: "and", children: []*ruleNode{left, right}}
+	}
+	return left, nil
+}
+
+// parseUnary: "!" unary | "(" or ")" | matcher
+func (p *ruleParser) parseUnary() (*ruleNode, error) {
+	switch p.peek().kind {
+	case ruleTokenNot:
+		p.next()
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &ruleNode{op: "not", children: []*ruleNode{child}}, nil
+	case ruleTokenLParen:
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(ruleTokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+	return p.parseMatcher()
+}
+
+// parseMatcher: ident "(" [ string ( "," string )* ] ")"
+func (p *ruleParser) parseMatcher() (*ruleNode, error) {
+	name, err := p.expect(ruleTokenIdent, "matcher name")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(ruleTokenLParen, "'('"); err != nil {
+		return nil, err
+	}
+	node := &ruleNode{op: "matcher", matcher: name.value}
+	if p.peek().kind == ruleTokenRParen {
+		p.next()
+		return node, nil
+	}
+	for {
+		arg, err := p.expect(ruleTokenString, "string argument")
+		if err != nil {
+			return nil, err
+		}
+		node.args = append(node.args, arg.value)
+		tok := p.next()
+		if tok.kind == ruleTokenRParen {
+			return node, nil
+		}
+		if tok.kind != ruleTokenComma {
+			return nil, fmt.Errorf("expected ',' or ')' at position %d", tok.pos)
+		}
+	}
+}
+
+// hostSet is the set of request hosts an expression can match: the hosts
+// that can be enumerated, patterns from HostRegexp that can't, and whether
+// the expression also matches hosts outside of these (any).
+type hostSet struct {
+	any      bool
+	hosts    []string
+	patterns []*regexp.Regexp
+}
+
+func (s hostSet) contains(host string) bool {
+	for _, h := range s.hosts {
+		if h == host {
+			return true
+		}
+	}
+	for _, re := range s.patterns {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostSet evaluates which hosts the expression can match. Hosts under a
+// negation never count as matchable: !Host(`a`) matches every host but a.
+func (n *ruleNode) hostSet() hostSet {
+	switch n.op {
+	case "not":
+		return hostSet{any: true}
+	case "or":
+		left, right := n.children[0].hostSet(), n.children[1].hostSet()
+		return hostSet{
+			any:      left.any || right.any,
+			hosts:    append(append([]string{}, left.hosts...), right.hosts...),
+			patterns: append(append([]*regexp.Regexp{}, left.patterns...), right.patterns...),
+		}
+	case "and":
+		left, right := n.children[0].hostSet(), n.children[1].hostSet()
+		if left.any && len(left.hosts) == 0 && len(left.patterns) == 0 {
+			return right
+		}
+		if right.any && len(right.hosts) == 0 && len(right.patterns) == 0 {
+			return left
+		}
+		// Both sides restrict the host: only hosts allowed by both match
+		var result hostSet
+		for _, h := range left.hosts {
+			if right.any || right.contains(h) {
+				result.hosts = append(result.hosts, h)
+			}
+		}
+		for _, h := range right.hosts {
+			if left.any || left.contains(h) {
+				result.hosts = append(result.hosts, h)
+			}
+		}
+		return result
+	}
+
+	switch strings.ToLower(n.matcher) {
+	case "host", "hostheader", "hostsni":
+		var set hostSet
+		for _, arg := range n.args {
+			host := strings.ToLower(strings.TrimSpace(arg))
+			if host == "*" {
+				// HostSNI(`*`): catch-all of non-TLS TCP routers
+				set.any = true
+				continue
+			}
+			set.hosts = append(set.hosts, host)
+		}
+		return set
+	case "hostregexp", "hostsniregexp":
+		var set hostSet
+		for _, arg := range n.args {
+			re, err := compileTraefikHostRegexp(arg)
+			if err != nil {
+				set.any = true
+				continue
+			}
+			set.patterns = append(set.patterns, re)
+		}
+		return set
+	}
+
+	// Path, Method, Header, ClientIP, ... don't restrict the host
+	return hostSet{any: true}
+}
+
+// traefikHostTemplateVar matches the {name} and {name:regexp} variables of
+// Traefik v2 HostRegexp templates.
+var traefikHostTemplateVar = regexp.MustCompile(`\{[^}:]+(?::([^}]+))?\}`)
+
+// compileTraefikHostRegexp compiles a HostRegexp argument: a v2 template
+// like {subdomain:[a-z]+}.example.com, or a v3 regexp like ^.+\.example\.com$
+func compileTraefikHostRegexp(arg string) (*regexp.Regexp, error) {
+	if !strings.Contains(arg, "{") {
+		return regexp.Compile("(?i)" + arg)
+	}
+
+	var pattern strings.Builder
+	last := 0
+	for _, m := range traefikHostTemplateVar.FindAllStringSubmatchIndex(arg, -1) {
+		pattern.WriteString(regexp.QuoteMeta(arg[last:m[0]]))
+		if m[2] >= 0 {
+			pattern.WriteString("(?:" + arg[m[2]:m[3]] + ")")
+		} else {
+			pattern.WriteString(`[^.]+`)
+		}
+		last = m[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(arg[last:]))
+	return regexp.Compile("(?i)^" + pattern.String() + "$")
+}
+
+// traefikRuleHosts returns the hosts a router rule can match, lowercased and
+// deduplicated. Hosts only reachable through HostRegexp are not returned.
+func traefikRuleHosts(rule string) ([]string, error) {
+	node, err := parseTraefikRule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, host := range node.hostSet().hosts {
+		if host != "" && !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
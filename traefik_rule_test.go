@@ -0,0 +1,122 @@
+package dockerdiscovery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraefikRuleHosts(t *testing.T) {
+	tests := []struct {
+		rule     string
+		expected []string
+	}{
+		// Simple v2/v3 host rules
+		{"Host(`app.example.com`)", []string{"app.example.com"}},
+		{"Host(`App.Example.COM`)", []string{"app.example.com"}},
+		{"HostSNI(`db.example.com`)", []string{"db.example.com"}},
+		{"HostHeader(`legacy.example.com`)", []string{"legacy.example.com"}},
+		{`Host("double.example.com")`, []string{"double.example.com"}},
+		{`Host('single.example.com')`, []string{"single.example.com"}},
+
+		// v2 multi-argument form
+		{"Host(`a.example.com`, `b.example.com`)", []string{"a.example.com", "b.example.com"}},
+		{"Host(`a.example.com`,`b.example.com`,`a.example.com`)", []string{"a.example.com", "b.example.com"}},
+		{"HostSNI(`a.example.com`, `b.example.com`)", []string{"a.example.com", "b.example.com"}},
+
+		// Boolean combinations
+		{"Host(`a.example.com`) || Host(`b.example.com`)", []string{"a.example.com", "b.example.com"}},
+		{"Host(`app.example.com`) && PathPrefix(`/api`)", []string{"app.example.com"}},
+		{"(Host(`a.example.com`) || Host(`b.example.com`)) && PathPrefix(`/api`)", []string{"a.example.com", "b.example.com"}},
+		{"Host(`app.example.com`) && (PathPrefix(`/api`) || PathPrefix(`/v2`))", []string{"app.example.com"}},
+		{"Host(`app.example.com`) && !PathPrefix(`/admin`)", []string{"app.example.com"}},
+		{"Host(`app.example.com`) && Method(`GET`, `HEAD`)", []string{"app.example.com"}},
+		{"Host(`app.example.com`) && Headers(`X-Env`, `prod`)", []string{"app.example.com"}},
+		{"Host(`app.example.com`) && ClientIP(`10.0.0.0/8`)", []string{"app.example.com"}},
+		{"Host(`a.example.com`) || PathPrefix(`/shared`)", []string{"a.example.com"}},
+
+		// Negated hosts can't match
+		{"!Host(`internal.example.com`)", nil},
+		{"PathPrefix(`/`) && !Host(`internal.example.com`)", nil},
+		{"Host(`a.example.com`) && !Host(`b.example.com`)", []string{"a.example.com"}},
+		{"!(Host(`a.example.com`) || Host(`b.example.com`))", nil},
+
+		// Contradictory conjunctions match nothing
+		{"Host(`a.example.com`) && Host(`b.example.com`)", nil},
+		{"Host(`a.example.com`, `b.example.com`) && Host(`b.example.com`)", []string{"b.example.com"}},
+
+		// HostRegexp is not enumerable, but filters enumerable hosts
+		{"HostRegexp(`{subdomain:[a-z]+}.example.com`)", nil},
+		{"HostRegexp(`^.+\\.example\\.com$`)", nil},
+		{"Host(`app.example.com`) || HostRegexp(`{sub:[a-z]+}.example.com`)", []string{"app.example.com"}},
+		{"Host(`app.example.com`, `app.other.org`) && HostRegexp(`{sub}.example.com`)", []string{"app.example.com"}},
+		{"Host(`app.example.com`, `app.other.org`) && HostRegexp(`^app\\.other\\.org$`)", []string{"app.other.org"}},
+
+		// TCP catch-all
+		{"HostSNI(`*`)", nil},
+		{"HostSNI(`*`) && ClientIP(`192.168.0.0/16`)", nil},
+
+		// Other matchers only
+		{"PathPrefix(`/api`)", nil},
+		{"Path(`/`)", nil},
+
+		// Whitespace and newlines
+		{"  Host( `a.example.com` )\n\t|| Host(`b.example.com`)  ", []string{"a.example.com", "b.example.com"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.rule, func(t *testing.T) {
+			hosts, err := traefikRuleHosts(tc.rule)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, hosts)
+		})
+	}
+}
+
+func TestTraefikRuleParseErrors(t *testing.T) {
+	for _, rule := range []string{
+		"",
+		"Host(`a.example.com`",
+		"Host(`a.example.com)",
+		"Host(`a.example.com`) &&",
+		"Host(`a.example.com`) & Path(`/`)",
+		"Host(`a.example.com`) Host(`b.example.com`)",
+		"(Host(`a.example.com`)",
+		"Host(a.example.com)",
+		"Host(`a`, )",
+		"Host:a.example.com",
+	} {
+		_, err := traefikRuleHosts(rule)
+		assert.NotNil(t, err, rule)
+	}
+}
+
+func TestCompileTraefikHostRegexp(t *testing.T) {
+	re, err := compileTraefikHostRegexp("{subdomain:[a-z]+}.example.com")
+	assert.Nil(t, err)
+	assert.True(t, re.MatchString("app.example.com"))
+	assert.True(t, re.MatchString("APP.example.com"))
+	assert.False(t, re.MatchString("app.example.org"))
+	assert.False(t, re.MatchString("app1.example.com"))
+
+	re, err = compileTraefikHostRegexp("{name}.example.com")
+	assert.Nil(t, err)
+	assert.True(t, re.MatchString("any-thing.example.com"))
+	assert.False(t, re.MatchString("a.b.example.com"))
+
+	re, err = compileTraefikHostRegexp(`^[a-z]+\.example\.com$`)
+	assert.Nil(t, err)
+	assert.True(t, re.MatchString("app.example.com"))
+	assert.False(t, re.MatchString("a.b.example.com"))
+}
+
+func TestTraefikLabelResolverMalformedRuleFallback(t *testing.T) {
+	// Unparseable rules still yield the hosts they mention
+	resolver := NewTraefikLabelResolver()
+	hosts := resolver.ruleHosts("container fa155d6fd141", &traefikRouter{
+		protocol: "http",
+		name:     "broken",
+		rule:     "Host(`a.example.com`) & PathPrefix(`/`)",
+	})
+	assert.Equal(t, []string{"a.example.com"}, hosts)
+}
@@ -0,0 +1,139 @@
+package dockerdiscovery
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Traefik 1.x declared frontends instead of routers:
+//
+//	traefik.frontend.rule=Host:a.com,b.com;PathPrefix:/api
+//	traefik.frontend.entryPoints=http,https
+//	traefik.port=8080
+//
+// and, for containers with several frontends, the same labels under a
+// segment name: traefik.<segment>.frontend.rule, traefik.<segment>.port.
+
+// traefikV1Routers collects the frontends declared in the labels as HTTP
+// routers, their rules translated to the v2 syntax, sorted by name.
+// Frontends whose rule can't be translated are left out, and counted.
+func traefikV1Routers(labels map[string]string) ([]*traefikRouter, int) {
+	routers := make(map[string]*traefikRouter)
+	invalid := make(map[string]bool)
+	for label, value := range labels {
+		var segment, option string
+		parts := strings.Split(label, ".")
+		switch {
+		case len(parts) == 3 && parts[0] == "traefik" && parts[1] == "frontend":
+			segment, option = "frontend", parts[2]
+		case len(parts) == 4 && parts[0] == "traefik" && parts[2] == "frontend":
+			segment, option = parts[1], parts[3]
+		default:
+			continue
+		}
+
+		router, ok := routers[segment]
+		if !ok {
+			router = &traefikRouter{protocol: "http", name: segment}
+			routers[segment] = router
+		}
+		switch option {
+		case "rule":
+			rule, err := convertTraefikV1Rule(value)
+			if err != nil {
+				// Dropped rather than given the default rule, as Traefik
+				// rejects it
+				log.Printf("[docker] Could not translate traefik v1 rule of frontend %s: %s", segment, err)
+				invalid[segment] = true
+				continue
+			}
+			router.rule = rule
+		case "entryPoints":
+			router.entrypoints = splitNameList(value)
+		}
+	}
+
+	result := make([]*traefikRouter, 0, len(routers))
+	for segment, router := range routers {
+		if !invalid[segment] {
+			result = append(result, router)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].name < result[j].name })
+	return result, len(invalid)
+}
+
+// convertTraefikV1Rule translates a v1 rule like
+// "Host:a.com,b.com;PathPrefix:/api" into the v2 expression
+// "Host(`a.com`, `b.com`) && PathPrefix(`/api`)".
+func convertTraefikV1Rule(rule string) (string, error) {
+	var matchers []string
+	for _, part := range strings.Split(rule, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return "", fmt.Errorf("invalid traefik v1 rule %q", rule)
+		}
+
+		var args []string
+		for _, arg := range splitTraefikV1Args(value) {
+			arg = strings.TrimSpace(arg)
+			if strings.Contains(arg, "`") {
+				return "", fmt.Errorf("invalid traefik v1 rule %q", rule)
+			}
+			args = append(args, "`"+arg+"`")
+		}
+		matchers = append(matchers, name+"("+strings.Join(args, ", ")+")")
+	}
+	if len(matchers) == 0 {
+		return "", fmt.Errorf("empty traefik v1 rule")
+	}
+	return strings.Join(matchers, " && "), nil
+}
+
+// splitTraefikV1Args splits matcher arguments on commas, except inside the
+// {name:regexp} variables of HostRegexp, which may contain commas.
+func splitTraefikV1Args(value string) []string {
+	var args []string
+	depth, start := 0, 0
+	for i, c := range value {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(args, value[start:])
+}
+
+// getTraefikV1Port returns the port of traefik.port, or else of the first
+// segment (by name) with a traefik.<segment>.port label.
+func getTraefikV1Port(labels map[string]string) string {
+	if port := labels["traefik.port"]; port != "" {
+		return port
+	}
+	var segments []string
+	for label, value := range labels {
+		parts := strings.Split(label, ".")
+		if len(parts) == 3 && parts[0] == "traefik" && parts[2] == "port" && value != "" {
+			segments = append(segments, parts[1])
+		}
+	}
+	if len(segments) == 0 {
+		return ""
+	}
+	sort.Strings(segments)
+	return labels["traefik."+segments[0]+".port"]
+}
@@ -0,0 +1,124 @@
+package dockerdiscovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertTraefikV1Rule(t *testing.T) {
+	tests := []struct {
+		rule     string
+		expected string
+	}{
+		{"Host:a.com", "Host(`a.com`)"},
+		{"Host:a.com,b.com", "Host(`a.com`, `b.com`)"},
+		{"Host: a.com, b.com ;PathPrefix:/api", "Host(`a.com`, `b.com`) && PathPrefix(`/api`)"},
+		{"HostRegexp:{sub:[a-z]{1,3}}.example.com", "HostRegexp(`{sub:[a-z]{1,3}}.example.com`)"},
+		{"PathPrefixStrip:/app;Host:app.example.com;", "PathPrefixStrip(`/app`) && Host(`app.example.com`)"},
+	}
+	for _, tc := range tests {
+		rule, err := convertTraefikV1Rule(tc.rule)
+		assert.Nil(t, err, tc.rule)
+		assert.Equal(t, tc.expected, rule)
+	}
+
+	for _, rule := range []string{"", ";", "a.com", ":a.com", "Host:a`b.com"} {
+		_, err := convertTraefikV1Rule(rule)
+		assert.NotNil(t, err, rule)
+	}
+}
+
+func TestTraefikV1Routers(t *testing.T) {
+	routers, invalid := traefikV1Routers(map[string]string{
+		"traefik.frontend.rule":               "Host:a.com,b.com",
+		"traefik.frontend.entryPoints":        "http,https",
+		"traefik.admin.frontend.rule":         "Host:admin.a.com;PathPrefix:/admin",
+		"traefik.broken.frontend.rule":        "a.com",
+		"traefik.broken.frontend.entryPoints": "http",
+		"traefik.port":                        "8080",
+		"traefik.http.routers.web.rule":       "Host(`v2.a.com`)",
+		"traefik.docker.network":              "proxy",
+		"traefik.frontend.passHostHeader":     "true",
+		"traefik.http.services.web.scheme":    "http",
+	})
+	assert.Equal(t, []*traefikRouter{
+		{protocol: "http", name: "admin", rule: "Host(`admin.a.com`) && PathPrefix(`/admin`)"},
+		{protocol: "http", name: "frontend", rule: "Host(`a.com`, `b.com`)", entrypoints: []string{"http", "https"}},
+	}, routers)
+	assert.Equal(t, 1, invalid)
+}
+
+func TestGetTraefikV1Port(t *testing.T) {
+	assert.Equal(t, "8080", getTraefikV1Port(map[string]string{"traefik.port": "8080", "traefik.api.port": "9000"}))
+	assert.Equal(t, "9000", getTraefikV1Port(map[string]string{"traefik.web.port": "9001", "traefik.api.port": "9000"}))
+	assert.Equal(t, "", getTraefikV1Port(map[string]string{"traefik.http.services.web.loadbalancer.server.port": "80"}))
+}
+
+func TestTraefikV1Resolver(t *testing.T) {
+	labels := map[string]string{
+		"traefik.frontend.rule":       "Host:legacy.example.com,old.example.com",
+		"traefik.admin.frontend.rule": "Host:admin.example.com;PathPrefix:/admin",
+		"traefik.port":                "8080",
+	}
+
+	// Off by default
+	resolver := NewTraefikLabelResolver()
+	domains, _ := resolver.resolve(genTraefikContainer("legacy", labels))
+	assert.Empty(t, domains)
+
+	resolver.v1 = true
+	domains, _ = resolver.resolve(genTraefikContainer("legacy", labels))
+	assert.Equal(t, []string{"admin.example.com", "legacy.example.com", "old.example.com"}, domains)
+
+	// Frontends with an invalid rule don't get the default rule
+	tmpl, err := newContainerTemplate("traefik_default_rule", "Host(`{{ .Name }}.example.com`)")
+	assert.Nil(t, err)
+	resolver.defaultRule = tmpl
+	domains, _ = resolver.resolve(genTraefikContainer("legacy", map[string]string{
+		"traefik.frontend.rule":        "legacy.example.com",
+		"traefik.frontend.entryPoints": "http",
+	}))
+	assert.Empty(t, domains)
+
+	labels["traefik.enable"] = "false"
+	domains, _ = resolver.resolve(genTraefikContainer("legacy", labels))
+	assert.Empty(t, domains)
+}
+
+func TestTraefikV1Config(t *testing.T) {
+	c := caddy.NewTestController("dns", `docker unix:///home/user/docker.sock {
+	traefik_cname traefik.lan
+	traefik_v1
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	assert.True(t, dd.traefikResolver.v1)
+
+	mock, tunnelSyncer := newTunnelTestSetup()
+	dd.tunnelSyncer = tunnelSyncer
+	container := genTraefikContainer("legacy", map[string]string{
+		"traefik.frontend.rule":             "Host:legacy.homelab.net",
+		"traefik.port":                      "8080",
+		"coredns.dockerdiscovery.cf_tunnel": "true",
+	})
+	container.HostConfig = &dockerapi.HostConfig{}
+	container.NetworkSettings = &dockerapi.NetworkSettings{}
+	assert.Nil(t, dd.updateContainerInfo(container))
+
+	result, _ := dd.containerInfoByDomain("legacy.homelab.net.")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "traefik.lan", result.target.cname)
+		assert.Equal(t, "http://localhost:8080", result.containerInfo.tunnelServiceURL)
+	}
+	assert.Eventually(t, func() bool { return mock.tunnelIngressCount("test-tunnel-uuid") == 2 }, time.Second, 10*time.Millisecond)
+
+	c = caddy.NewTestController("dns", `docker {
+	traefik_v1 yes
+}`)
+	_, err = createPlugin(c)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,93 @@
+package dockerdiscovery
+
+import "sync"
+
+// keyedQueue runs work one task at a time per key, in the order tasks are
+// added, and the tasks of different keys concurrently. A task added while
+// its key is busy waits for the running one, and replaces the task already
+// waiting, if any: bursts of work on a key are coalesced into the last task,
+// which must therefore bring the key to its latest state by itself.
+type keyedQueue struct {
+	mutex   sync.Mutex
+	pending map[string]*keyedTask // keys being worked on, with their next task
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// keyedTask is a queued task, and the tasks it replaced: done is closed
+// once it ran.
+type keyedTask struct {
+	run  func()
+	done chan struct{}
+}
+
+func newKeyedQueue() *keyedQueue {
+	return &keyedQueue{pending: make(map[string]*keyedTask)}
+}
+
+// add queues a task for the key. It returns a channel closed once the task,
+// or the task that replaced it, ran, or nil when the queue is shut down, in
+// which case the task is dropped.
+func (q *keyedQueue) add(key string, task func()) <-chan struct{} {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	if next, busy := q.pending[key]; busy {
+		if next == nil {
+			next = &keyedTask{done: make(chan struct{})}
+			q.pending[key] = next
+		}
+		next.run = task
+		return next.done
+	}
+	next := &keyedTask{run: task, done: make(chan struct{})}
+	q.pending[key] = nil
+	q.workers.Add(1)
+	go q.work(key, next)
+	return next.done
+}
+
+// work runs the tasks of a key until none is waiting.
+func (q *keyedQueue) work(key string, task *keyedTask) {
+	defer q.workers.Done()
+	for task != nil {
+		task.run()
+		close(task.done)
+
+		q.mutex.Lock()
+		task = q.pending[key]
+		if task == nil {
+			delete(q.pending, key)
+		} else {
+			q.pending[key] = nil
+		}
+		q.mutex.Unlock()
+	}
+}
+
+// shutdown stops accepting tasks and waits for the queued ones to be done.
+func (q *keyedQueue) shutdown() {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+	q.workers.Wait()
+}
+
+// waitTasks waits until the tasks are done, or stop is closed. It returns
+// false in the latter case. Tasks dropped by a shut down queue are skipped.
+func waitTasks(tasks []<-chan struct{}, stop <-chan struct{}) bool {
+	for _, done := range tasks {
+		if done == nil {
+			continue
+		}
+		select {
+		case <-done:
+		case <-stop:
+			return false
+		}
+	}
+	return true
+}
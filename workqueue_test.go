@@ -0,0 +1,161 @@
+package dockerdiscovery
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyedQueueSerializesKeys(t *testing.T) {
+	q := newKeyedQueue()
+	var mutex sync.Mutex
+	order := make(map[string][]int)
+	var running [4]int32
+
+	// Interleaved tasks of 4 keys, from concurrent producers
+	var producers sync.WaitGroup
+	for k := 0; k < 4; k++ {
+		producers.Add(1)
+		go func(k int) {
+			defer producers.Done()
+			key := fmt.Sprintf("container-%d", k)
+			for i := 0; i < 50; i++ {
+				i := i
+				q.add(key, func() {
+					if atomic.AddInt32(&running[k], 1) != 1 {
+						t.Errorf("concurrent tasks for %s", key)
+					}
+					mutex.Lock()
+					order[key] = append(order[key], i)
+					mutex.Unlock()
+					time.Sleep(time.Millisecond)
+					atomic.AddInt32(&running[k], -1)
+				})
+			}
+		}(k)
+	}
+	producers.Wait()
+	q.shutdown()
+
+	for key, tasks := range order {
+		// Coalesced, but never out of order, and the last task always runs
+		for i := 1; i < len(tasks); i++ {
+			assert.Less(t, tasks[i-1], tasks[i], key)
+		}
+		assert.Equal(t, 49, tasks[len(tasks)-1], key)
+		assert.Less(t, len(tasks), 50, key)
+	}
+	assert.Len(t, order, 4)
+}
+
+func TestKeyedQueueRunsKeysConcurrently(t *testing.T) {
+	q := newKeyedQueue()
+	a, b := make(chan struct{}), make(chan struct{})
+	// Each task waits for the other: deadlock unless they run concurrently
+	q.add("a", func() { close(a); <-b })
+	q.add("b", func() { close(b); <-a })
+
+	done := make(chan struct{})
+	go func() {
+		q.shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("tasks of different keys didn't run concurrently")
+	}
+}
+
+func TestKeyedQueueCoalesces(t *testing.T) {
+	q := newKeyedQueue()
+	release := make(chan struct{})
+	var ran []string
+	var mutex sync.Mutex
+	task := func(name string) func() {
+		return func() {
+			mutex.Lock()
+			ran = append(ran, name)
+			mutex.Unlock()
+		}
+	}
+	first := q.add("web", func() { <-release; task("start")() })
+	replaced := q.add("web", task("die"))
+	q.add("web", task("start again"))
+	last := q.add("web", task("die again"))
+	// Replaced tasks are done with the task that replaced them
+	assert.Equal(t, replaced, last)
+	close(release)
+	assert.True(t, waitTasks([]<-chan struct{}{first, replaced}, nil))
+	mutex.Lock()
+	assert.Equal(t, []string{"start", "die again"}, ran)
+	mutex.Unlock()
+	q.shutdown()
+}
+
+func TestKeyedQueueShutdownDrains(t *testing.T) {
+	q := newKeyedQueue()
+	var done int32
+	for i := 0; i < 10; i++ {
+		q.add(fmt.Sprint(i), func() {
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&done, 1)
+		})
+	}
+	q.shutdown()
+	assert.Equal(t, int32(10), atomic.LoadInt32(&done))
+
+	assert.Nil(t, q.add("late", func() { t.Error("task run after shutdown") }))
+	q.shutdown()
+}
+
+func TestInterleavedContainerEvents(t *testing.T) {
+	fake := newFakeDockerAPI()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	c := caddy.NewTestController("dns", `docker {
+	domain docker.loc
+}`)
+	dd, err := createPlugin(c)
+	assert.Nil(t, err)
+	client, err := dockerapi.NewClient(server.URL)
+	assert.Nil(t, err)
+	ep := &dockerEndpoint{url: server.URL, client: client}
+
+	// A container that exits right after starting: the inspect for its
+	// start event returns after its die event came in
+	web := genRunningContainer("aa155d6fd141e29256c286070d2d44b3f45f1e46822578f1e7d66c1e7981e6c7", "web", "172.17.0.2")
+	fake.set(web)
+	fake.inspectDelay = 50 * time.Millisecond
+	event := func(action string) *dockerapi.APIEvents {
+		return &dockerapi.APIEvents{Type: "container", Action: action, Actor: dockerapi.APIActor{ID: web.ID}}
+	}
+	dd.handleEvent(ep, event("start"))
+	time.Sleep(10 * time.Millisecond)
+	fake.set()
+	dd.handleEvent(ep, event("die"))
+	dd.queue.shutdown()
+	assert.Empty(t, dd.addressesByDomain("web.docker.loc.", false))
+
+	// Bursts for the same container converge to its last state
+	dd.queue = newKeyedQueue()
+	fake.mutex.Lock()
+	fake.inspectDelay = 0
+	fake.mutex.Unlock()
+	fake.set(web)
+	for i := 0; i < 10; i++ {
+		dd.handleEvent(ep, event("die"))
+		dd.handleEvent(ep, event("start"))
+	}
+	dd.handleEvent(ep, &dockerapi.APIEvents{Type: "network", Action: "disconnect", Actor: dockerapi.APIActor{ID: "net", Attributes: map[string]string{"container": web.ID}}})
+	dd.queue.shutdown()
+	assert.NotEmpty(t, dd.addressesByDomain("web.docker.loc.", false))
+}